@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"log"
+	"os"
+
+	"github.com/kei2100/h-fwd/config"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caCmd.AddCommand(caExportCmd)
+	RootCmd.AddCommand(caCmd)
+}
+
+// caCmd for the local CA
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "manage the local CA which issues the listener certificates for the --tls-local-ca",
+}
+
+// caExportCmd prints the certificate of the local CA
+var caExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "print the local CA certificate PEM for the trust stores. the local CA is created if not exists",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := stateDir
+		if configPath != "" && !cmd.Flags().Changed("state-dir") {
+			f, err := config.LoadFile(configPath)
+			if err != nil {
+				log.Fatalf("failed to setup configuration:\n%v", err)
+			}
+			if f.StateDir != "" {
+				dir = f.StateDir
+			}
+		}
+		ca, err := config.LoadLocalCA(dir)
+		if err != nil {
+			log.Fatalf("failed to load the local CA: %v", err)
+		}
+		os.Stdout.Write(ca.CertPEM())
+	},
+}
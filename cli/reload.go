@@ -0,0 +1,223 @@
+package cli
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+	"github.com/kei2100/h-fwd/hfwd"
+	"github.com/spf13/cobra"
+)
+
+// reloader reloads the configuration file and the certificates on change or on SIGHUP,
+// and swaps the handler and the tls.Config of the listener.
+// Failed reloads are logged and keep the old state.
+type reloader struct {
+	cmd     *cobra.Command
+	args    []string
+	handler *hfwd.SwapHandler
+	srvConf config.Server   // configuration of the running listener
+	table   []*config.Route // routing table of the latest loaded configuration
+
+	tlsEnabled bool
+	tlsConf    atomic.Value // *tls.Config
+
+	files   map[string]fileStamp // files to watch
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// fileStamp is the modification stamp of a file. zero value means the file does not exist
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func newReloader(cmd *cobra.Command, args []string, handler *hfwd.SwapHandler, srvConf *config.Server, table []*config.Route) *reloader {
+	rl := &reloader{
+		cmd:        cmd,
+		args:       args,
+		handler:    handler,
+		srvConf:    *srvConf,
+		table:      table,
+		tlsEnabled: srvConf.Enabled(),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if rl.tlsEnabled {
+		rl.storeTLSConfig(srvConf.TLSServerConfig())
+	}
+	rl.files = stampFiles(watchFiles(srvConf, table))
+	return rl
+}
+
+// tlsConfig returns *tls.Config for the listener, which delegates to the latest loaded *tls.Config
+func (rl *reloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return rl.tlsConf.Load().(*tls.Config), nil
+		},
+	}
+}
+
+func (rl *reloader) storeTLSConfig(cfg *tls.Config) {
+	c := cfg.Clone()
+	// the ALPN is negotiated by the config returned from the GetConfigForClient
+	c.NextProtos = []string{"h2", "http/1.1"}
+	rl.tlsConf.Store(c)
+}
+
+// run watches the files every interval and SIGHUP until the stop
+func (rl *reloader) run(interval time.Duration) {
+	defer close(rl.stopped)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-rl.done:
+			return
+		case <-hup:
+			log.Printf("hfwd received SIGHUP. reloading the configuration")
+			rl.reload()
+		case <-tick:
+			if changed := rl.changedFile(); changed != "" {
+				log.Printf("hfwd detected the change of %v. reloading the configuration", changed)
+				rl.reload()
+			}
+		}
+	}
+}
+
+// stop stops the run and waits for it to return
+func (rl *reloader) stop() {
+	close(rl.done)
+	<-rl.stopped
+}
+
+// changedFile returns the path of a changed file or blank
+func (rl *reloader) changedFile() string {
+	for path, stamp := range rl.files {
+		if stampFile(path) != stamp {
+			return path
+		}
+	}
+	return ""
+}
+
+func (rl *reloader) reload() {
+	// the files are stamped even if the reload fails, so that the broken files are not reloaded repeatedly
+	restamp := func() {
+		paths := make([]string, 0, len(rl.files))
+		for p := range rl.files {
+			paths = append(paths, p)
+		}
+		rl.files = stampFiles(paths)
+	}
+
+	srvConf, table, err := loadConfig(rl.cmd, rl.args)
+	if err != nil {
+		restamp()
+		log.Printf("hfwd failed to reload the configuration. keeps the old configuration:\n%v", err)
+		return
+	}
+	if srvConf.Enabled() != rl.tlsEnabled {
+		restamp()
+		log.Printf("hfwd failed to reload the configuration. switching the tls of the listener requires a restart")
+		return
+	}
+	router, err := hfwd.NewRouter(table)
+	if err != nil {
+		restamp()
+		log.Printf("hfwd failed to reload the forward proxy. keeps the old configuration: %v", err)
+		return
+	}
+	rl.files = stampFiles(watchFiles(&srvConf, table))
+	if rl.tlsEnabled {
+		rl.storeTLSConfig(srvConf.TLSServerConfig())
+	}
+	rl.handler.Swap(router)
+	warnInsecure(rl.table, table)
+	rl.table = table
+	warnRestart(&rl.srvConf, &srvConf)
+	log.Printf("hfwd reloaded the configuration")
+}
+
+// warnInsecure logs the routes which skip the verification of the destination certificate,
+// unless the route of the same name in the prev already skips it
+func warnInsecure(prev, table []*config.Route) {
+	insecure := make(map[string]bool, len(prev))
+	for _, r := range prev {
+		insecure[r.Name()] = r.Insecure
+	}
+	for _, r := range table {
+		if r.Insecure && !insecure[r.Name()] {
+			log.Printf("hfwd WARNING: the verification of the destination certificate of the route %v is disabled by the insecure option. "+
+				"the connection is vulnerable to the man-in-the-middle attacks", r.Name())
+		}
+	}
+}
+
+// warnRestart logs the settings of the listener which are changed but take effect only after a restart
+func warnRestart(running, loaded *config.Server) {
+	settings := []struct {
+		name    string
+		changed bool
+	}{
+		{name: "listen", changed: running.Addr != loaded.Addr},
+		{name: "admin", changed: running.AdminAddr != loaded.AdminAddr},
+		{name: "read-header-timeout", changed: running.ReadHeaderTimeout != loaded.ReadHeaderTimeout},
+		{name: "write-timeout", changed: running.WriteTimeout != loaded.WriteTimeout},
+		{name: "idle-timeout", changed: running.IdleTimeout != loaded.IdleTimeout},
+		{name: "h2c", changed: running.H2C != loaded.H2C},
+		{name: "grace-period", changed: running.GracePeriod != loaded.GracePeriod},
+		{name: "reload-interval", changed: running.ReloadInterval != loaded.ReloadInterval},
+	}
+	for _, s := range settings {
+		if s.changed {
+			log.Printf("hfwd ignored the change of the %v. it requires a restart", s.name)
+		}
+	}
+}
+
+// watchFiles returns the paths of the configuration file and the certificates
+func watchFiles(srvConf *config.Server, table []*config.Route) []string {
+	var paths []string
+	if configPath != "" {
+		paths = append(paths, configPath)
+	}
+	paths = append(paths, srvConf.TLSServer.FilePaths()...)
+	for _, r := range table {
+		paths = append(paths, r.TLSClient.FilePaths()...)
+	}
+	return paths
+}
+
+func stampFiles(paths []string) map[string]fileStamp {
+	m := make(map[string]fileStamp, len(paths))
+	for _, p := range paths {
+		m[p] = stampFile(p)
+	}
+	return m
+}
+
+func stampFile(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+}
@@ -0,0 +1,209 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/hfwd"
+)
+
+// withReloader runs the fn with the reloader and the proxy which are started by the configuration file of the content
+func withReloader(t *testing.T, content string, fn func(rl *reloader, proxyURL string)) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "hfwd-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { configPath = old }(configPath)
+	configPath = filepath.Join(dir, "hfwd.yaml")
+	writeConfig(t, content)
+
+	srvConf, table, err := loadConfig(RootCmd, nil)
+	if err != nil {
+		t.Fatalf("failed to load the configuration: %v", err)
+	}
+	router, err := hfwd.NewRouter(table)
+	if err != nil {
+		t.Fatalf("failed to create the router: %v", err)
+	}
+	handler := hfwd.NewSwapHandler(router)
+	defer handler.Close()
+	proxy := httptest.NewServer(handler)
+	defer proxy.Close()
+
+	fn(newReloader(RootCmd, nil, handler, &srvConf, table), proxy.URL)
+}
+
+// writeConfig writes the content to the configuration file, and advances the modification time
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mtime := fi.ModTime().Add(time.Second)
+	if err := os.Chtimes(configPath, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newBackend returns the destination server which responds the name
+func newBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+}
+
+func get(t *testing.T, url string) string {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// waitFor waits until the proxy responds the want, and calls the fn on each try
+func waitFor(t *testing.T, proxyURL, want string, fn func()) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		fn()
+		if get(t, proxyURL) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("proxy did not respond %v", want)
+}
+
+func TestReloader_reload(t *testing.T) {
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+
+	withReloader(t, "destination: "+a.URL+"\n", func(rl *reloader, proxyURL string) {
+		if g, w := get(t, proxyURL), "a"; g != w {
+			t.Fatalf("body got %v, want %v", g, w)
+		}
+
+		t.Run("router swap", func(t *testing.T) {
+			writeConfig(t, "destination: "+b.URL+"\n")
+			rl.reload()
+			if g, w := get(t, proxyURL), "b"; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+		})
+
+		t.Run("invalid config rollback", func(t *testing.T) {
+			for _, content := range []string{
+				"destination: [",
+				"destination: " + a.URL + "\nerror-format: unknown\n",
+			} {
+				writeConfig(t, content)
+				rl.reload()
+				if g, w := get(t, proxyURL), "b"; g != w {
+					t.Errorf("%q: body got %v, want the old %v", content, g, w)
+				}
+				if changed := rl.changedFile(); changed != "" {
+					t.Errorf("%q: changedFile got %v, want the broken file stamped", content, changed)
+				}
+			}
+		})
+
+		t.Run("restart warning", func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			writeConfig(t, "destination: "+a.URL+"\nlisten: 127.0.0.1:0\ngrace-period: 1s\n")
+			rl.reload()
+			if g, w := get(t, proxyURL), "a"; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+			for _, name := range []string{"listen", "grace-period"} {
+				if !strings.Contains(buf.String(), "change of the "+name+".") {
+					t.Errorf("log got %q, want the warning of the %v", buf.String(), name)
+				}
+			}
+			if strings.Contains(buf.String(), "change of the admin.") {
+				t.Errorf("log got %q, want no warning of the unchanged admin", buf.String())
+			}
+		})
+
+		t.Run("insecure warning", func(t *testing.T) {
+			var buf bytes.Buffer
+			log.SetOutput(&buf)
+			defer log.SetOutput(os.Stderr)
+
+			for i, te := range []struct {
+				insecure bool
+				want     int
+			}{
+				{insecure: true, want: 1},
+				{insecure: true, want: 1},
+				{insecure: false, want: 1},
+				{insecure: true, want: 2},
+			} {
+				writeConfig(t, fmt.Sprintf("destination: %v\ninsecure: %v\n", a.URL, te.insecure))
+				rl.reload()
+				if g, w := strings.Count(buf.String(), "insecure option"), te.want; g != w {
+					t.Errorf("%v: count of the warnings got %v, want %v", i, g, w)
+				}
+			}
+		})
+	})
+}
+
+func TestReloader_run(t *testing.T) {
+	a, b := newBackend("a"), newBackend("b")
+	defer a.Close()
+	defer b.Close()
+
+	t.Run("stat poll", func(t *testing.T) {
+		withReloader(t, "destination: "+a.URL+"\n", func(rl *reloader, proxyURL string) {
+			go rl.run(10 * time.Millisecond)
+			defer rl.stop()
+
+			writeConfig(t, "destination: "+b.URL+"\n")
+			waitFor(t, proxyURL, "b", func() {})
+		})
+	})
+
+	t.Run("SIGHUP", func(t *testing.T) {
+		// keeps the process alive on the SIGHUP which arrives before the run starts to watch it
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		withReloader(t, "destination: "+a.URL+"\n", func(rl *reloader, proxyURL string) {
+			go rl.run(0)
+			defer rl.stop()
+
+			writeConfig(t, "destination: "+b.URL+"\n")
+			waitFor(t, proxyURL, "b", func() {
+				syscall.Kill(os.Getpid(), syscall.SIGHUP)
+			})
+		})
+	})
+}
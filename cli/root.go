@@ -1,24 +1,44 @@
 package cli
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"log"
 
-	"errors"
 	"net"
-	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/kei2100/h-fwd/config"
+	"github.com/kei2100/h-fwd/errors"
 	"github.com/kei2100/h-fwd/hfwd"
 	"github.com/spf13/cobra"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // listen addr:port
 var lnAddr string
 var verbose bool
 
+// listen addr:port for the admin endpoint
+var adminAddr string
+
+// path of the configuration file
+var configPath string
+
+var (
+	// option parameters for the routing table
+	routes []string
+)
+
 var (
 	// option parameters for the url configuration
 	rewritePaths []string
@@ -26,105 +46,737 @@ var (
 
 var (
 	// option parameters for the headers configuration
-	headers  []string
-	username string
-	password string
+	headers        []string
+	requestHeaders []string
+	username       string
+	password       string
 )
 
 var (
 	// options parameters for the client certification
 	caCertPath     string
+	caCertOnly     bool
 	pkcs12Path     string
 	pkcs12Password string
+	clientCertPath string
+	clientKeyPath  string
+	clientKeyPass  string
+	minTLSVersion  string
+	maxTLSVersion  string
+	cipherSuites   []string
+	curves         []string
+	serverName     string
+	insecure       bool
+)
+
+var (
+	// option parameters for the upstream configuration
+	balance             string
+	healthCheckPath     string
+	healthCheckInterval time.Duration
+	healthCheckTimeout  time.Duration
+	maxFails            int
+	failCooldown        time.Duration
+	destinationH2C      bool
+)
+
+var (
+	// option parameters for the retry configuration
+	retries            int
+	retryBackoff       time.Duration
+	retryMaxBackoff    time.Duration
+	retryStatus        []int
+	retryNonIdempotent bool
+	retryBodyLimit     int64
+	retryBudget        time.Duration
+)
+
+var (
+	// option parameters for the error response configuration
+	errorFormat string
+)
+
+var (
+	// option parameters for the timeouts of the forwarding
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	idleConnTimeout       time.Duration
+	requestTimeout        time.Duration
+)
+
+var (
+	// option parameters for the headers of the client certificate
+	clientCertSubjectHeader string
+	clientCertSANHeader     string
+	clientCertHeader        string
+)
+
+var (
+	// option parameters for the streaming
+	flushInterval time.Duration
+)
+
+var (
+	// option parameters for the forwarded headers
+	xForwarded     string
+	forwarded      string
+	via            string
+	trustedProxies []string
+)
+
+var (
+	// option parameters for the reverse rewrite of the response headers
+	rewriteLocation bool
+	rewriteCookie   bool
+)
+
+var (
+	// option parameters for the rewrite of the response bodies
+	replaceBody      []string
+	rewriteBody      []string
+	rewriteBodyTypes []string
+	rewriteBodyLimit int64
+)
+
+var (
+	// option parameters for the rewrite of the response headers
+	responseHeaders []string
+)
+
+var (
+	// option parameters for the CORS mode
+	cors            bool
+	corsOrigins     []string
+	corsMethods     []string
+	corsHeaders     []string
+	corsCredentials bool
+	corsMaxAge      time.Duration
+	corsReflect     bool
+)
+
+var (
+	// option parameters for the listener
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+
+	tlsCertPaths      []string
+	tlsKeyPaths       []string
+	tlsPKCS12Paths    []string
+	tlsPKCS12Password string
+	tlsLocalCA        bool
+	tlsLocalCANames   []string
+	stateDir          string
+	clientCAPath      string
+	clientAuth        string
+	listenH2C         bool
+
+	reloadInterval time.Duration
+	gracePeriod    time.Duration
 )
 
 func init() {
 	flags := RootCmd.PersistentFlags()
 
+	flags.StringVarP(&configPath, "config", "c", "", "path of the configuration file (.yaml, .yml or .toml). flags take precedence over the file")
 	flags.StringVarP(&lnAddr, "listen", "l", "127.0.0.1:8080", "listen addr:port")
 	flags.BoolVar(&verbose, "verbose", false, "verbose output")
+	flags.StringVar(&adminAddr, "admin", "", "listen addr:port for the admin endpoint. GET /upstreams reports the status of the upstreams")
+	flags.StringArrayVar(&routes, "route", []string{}, "list for the additional routes. the route forwards requests which match to the host and/or path prefix to the destination (--route api.localhost/v1=https://api.example.com --route /static=https://cdn.example.com)")
 
 	flags.StringSliceVarP(&rewritePaths, "rewrite", "r", []string{}, "list for path rewrite (-r /old:/new -r /o:/n OR -r /old:/new,/o:/n)")
 	flags.StringVarP(&username, "username", "u", "", "username for the basic authentication")
 	flags.StringVarP(&password, "password", "p", "", "password for the basic authentication")
-	flags.StringSliceVarP(&headers, "header", "H", []string{}, "list for the additional http headers (-H Host:https://custom.example.com -H 'User-Agent:My Agent'")
+	flags.StringSliceVarP(&headers, "header", "H", []string{}, "list for the additional http headers. the value can contain {client_ip}, {host}, {request_id}, {time}, {time_unix} and {env:NAME}. {{ is the literal { (-H Host:https://custom.example.com -H 'User-Agent:My Agent' -H 'X-Request-Id:{request_id}')")
+	flags.StringArrayVar(&requestHeaders, "request-header", []string{}, "list for the rules of the request headers applied after the --header in the form of <add|set|delete|replace> <name>[:<value>]. the value of the replace is <regexp>=<replacement> (--request-header 'delete Cookie' --request-header 'replace Origin: ^.*$={env:ORIGIN}')")
 
 	flags.StringVar(&caCertPath, "ca-cert", "", "path of the additional CA certificate PEM")
 	flags.StringVar(&pkcs12Path, "pkcs12", "", "path of the PKCS12 encoded file for the client certification")
 	flags.StringVar(&pkcs12Password, "pkcs12-password", "", "password for the PKCS12 file")
+	flags.StringVar(&clientCertPath, "client-cert", "", "path of the client certificate PEM for the client certification. the PEM can contain the intermediate CA certificates")
+	flags.StringVar(&clientKeyPath, "client-key", "", "path of the private key PEM of the --client-cert (PKCS8, PKCS1 or EC)")
+	flags.StringVar(&clientKeyPass, "client-key-password", "", "password for the encrypted --client-key")
+	flags.BoolVar(&caCertOnly, "ca-cert-only", false, "use the --ca-cert as the only root CA instead of appending it to the system cert pool")
+	flags.StringVar(&minTLSVersion, "min-tls-version", "", "min TLS version for the destination (1.0, 1.1, 1.2 or 1.3)")
+	flags.StringVar(&maxTLSVersion, "max-tls-version", "", "max TLS version for the destination (1.0, 1.1, 1.2 or 1.3)")
+	flags.StringSliceVar(&cipherSuites, "cipher-suites", []string{}, "list for the cipher suites for the destination (--cipher-suites TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)")
+	flags.StringSliceVar(&curves, "curves", []string{}, "list for the curve preferences for the destination (P256, P384, P521 or X25519)")
+	flags.StringVar(&serverName, "server-name", "", "server name for the SNI and the certificate verification of the destination instead of the destination host")
+	flags.BoolVar(&insecure, "insecure", false, "skip the certificate verification of the destination. DO NOT use this in production")
+
+	flags.StringVar(&balance, "balance", config.BalanceRoundRobin, "load balancing policy for the comma separated destination URLs (round-robin, random or least-outstanding)")
+	flags.StringVar(&healthCheckPath, "health-check-path", "", "path for the active health check of the destinations. the upstream is unhealthy while the check fails")
+	flags.DurationVar(&healthCheckInterval, "health-check-interval", config.DefaultHealthCheckInterval, "interval of the active health check")
+	flags.DurationVar(&healthCheckTimeout, "health-check-timeout", config.DefaultHealthCheckTimeout, "timeout of the active health check")
+	flags.IntVar(&maxFails, "max-fails", 0, "count of the consecutive transport errors to eject the upstream. 0 disables the ejection")
+	flags.DurationVar(&failCooldown, "fail-cooldown", config.DefaultFailCooldown, "duration to bring back the ejected upstream")
+	flags.BoolVar(&destinationH2C, "destination-h2c", false, "speak the cleartext HTTP/2 (h2c) to the http destinations, e.g. for the gRPC servers. only the --dial-timeout and --request-timeout apply to the h2c. HTTP/2 to the https destinations is negotiated by the TLS ALPN")
+
+	flags.IntVar(&retries, "retries", 0, "max count of the retries. requests of the idempotent methods are retried on the transport errors and the --retry-status")
+	flags.DurationVar(&retryBackoff, "retry-backoff", config.DefaultRetryBackoff, "base duration of the exponential backoff with jitter between the retries")
+	flags.DurationVar(&retryMaxBackoff, "retry-max-backoff", config.DefaultRetryMaxBackoff, "max duration of the exponential backoff between the retries")
+	flags.IntSliceVar(&retryStatus, "retry-status", []int{}, "list for the response status codes to retry (--retry-status 502,503,504)")
+	flags.BoolVar(&retryNonIdempotent, "retry-non-idempotent", false, "retry requests of the non-idempotent methods such as POST on the --retry-status as well. they are never retried on the transport errors")
+	flags.Int64Var(&retryBodyLimit, "retry-body-limit", config.DefaultRetryBodyLimit, "max size in bytes of the request body buffered for the retries. larger requests are not retried")
+	flags.DurationVar(&retryBudget, "retry-budget", 0, "max duration of a request including the retries. 0 means unlimited")
+
+	flags.StringVar(&errorFormat, "error-format", config.ErrorFormatText, "format of the error response body when the forwarding fails (none, text or json)")
+
+	flags.DurationVar(&dialTimeout, "dial-timeout", config.DefaultDialTimeout, "timeout of the dial to the destination")
+	flags.DurationVar(&tlsHandshakeTimeout, "tls-handshake-timeout", config.DefaultTLSHandshakeTimeout, "timeout of the TLS handshake with the destination")
+	flags.DurationVar(&responseHeaderTimeout, "response-header-timeout", 0, "timeout of waiting for the response headers from the destination. 0 means no timeout")
+	flags.DurationVar(&idleConnTimeout, "idle-conn-timeout", config.DefaultIdleConnTimeout, "max duration of the idle connection to the destination is kept")
+	flags.DurationVar(&requestTimeout, "request-timeout", 0, "deadline of the whole request including the retries and the response body. 0 means no timeout")
+
+	flags.DurationVar(&readHeaderTimeout, "read-header-timeout", config.DefaultReadHeaderTimeout, "timeout of reading the request headers from the client. 0 means no timeout")
+	flags.DurationVar(&writeTimeout, "write-timeout", 0, "timeout of writing the response to the client. 0 means no timeout")
+	flags.DurationVar(&idleTimeout, "idle-timeout", config.DefaultIdleTimeout, "max duration of the idle keep-alive connection from the client is kept. 0 means no timeout")
+	flags.StringSliceVar(&tlsCertPaths, "tls-cert", []string{}, "list for the paths of the certificate PEM to serve HTTPS on the listener. the certificate is selected by the SNI (--tls-cert a.pem --tls-key a-key.pem --tls-cert b.pem --tls-key b-key.pem)")
+	flags.StringSliceVar(&tlsKeyPaths, "tls-key", []string{}, "list for the paths of the private key PEM paired with the --tls-cert")
+	flags.StringSliceVar(&tlsPKCS12Paths, "tls-pkcs12", []string{}, "list for the paths of the PKCS12 encoded file to serve HTTPS on the listener")
+	flags.StringVar(&tlsPKCS12Password, "tls-pkcs12-password", "", "password for the --tls-pkcs12 files")
+	flags.BoolVar(&tlsLocalCA, "tls-local-ca", false, "serve HTTPS on the listener with the certificates issued on the fly by the local CA in the --state-dir. 'hfwd ca export' prints the CA certificate")
+	flags.StringSliceVar(&tlsLocalCANames, "tls-local-ca-name", []string{}, "list for the server names which the --tls-local-ca issues the certificates for in addition to localhost and the listener IP. *.example.test allows the subdomains (--tls-local-ca-name app.test,*.app.test)")
+	flags.StringVar(&stateDir, "state-dir", "", "directory where the local CA is persisted (default $HOME/.hfwd)")
+	flags.StringVar(&clientCAPath, "client-ca", "", "path of the CA certificate PEM to verify the client certificates on the listener")
+	flags.StringVar(&clientAuth, "client-auth", "", "client authentication mode with the --client-ca, require or verify-if-given (default require)")
+	flags.BoolVar(&listenH2C, "h2c", false, "accept the cleartext HTTP/2 (h2c) on the listener without the TLS. HTTP/2 over the TLS is always accepted")
+	flags.DurationVar(&gracePeriod, "grace-period", config.DefaultGracePeriod, "max duration of waiting for the in-flight requests to complete on SIGINT or SIGTERM")
+	flags.DurationVar(&reloadInterval, "reload-interval", config.DefaultReloadInterval, "interval of watching the configuration file and the certificates for the reload. 0 disables the watching. SIGHUP always reloads them")
+
+	flags.StringVar(&clientCertSubjectHeader, "client-cert-subject-header", "", "header name to forward the subject of the verified client certificate (e.g. X-Client-Cert-Subject)")
+	flags.StringVar(&clientCertSANHeader, "client-cert-san-header", "", "header name to forward the subject alternative names of the verified client certificate (e.g. X-Client-Cert-SAN)")
+	flags.StringVar(&clientCertHeader, "client-cert-header", "", "header name to forward the URL encoded PEM of the verified client certificate (e.g. X-Client-Cert)")
+
+	flags.DurationVar(&flushInterval, "flush-interval", 0, "interval of flushing the response body to the client. 0 disables the flushing, negative flushes after each read. text/event-stream and unknown length responses are always flushed after each read")
+
+	flags.StringVar(&xForwarded, "x-forwarded", "", "mode of the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers, append, replace or strip. the incoming values are passed through by default")
+	flags.StringVar(&forwarded, "forwarded", "", "mode of the RFC 7239 Forwarded header, append, replace or strip. the incoming values are passed through by default")
+	flags.StringVar(&via, "via", "", "mode of the Via header, append, replace or strip. the incoming values are passed through by default")
+	flags.StringSliceVar(&trustedProxies, "trusted-proxy", []string{}, "list for the CIDRs or IPs of the trusted proxies. the append mode honours the incoming values only from them (--trusted-proxy 10.0.0.0/8,192.168.0.1)")
+
+	flags.BoolVar(&rewriteLocation, "rewrite-location", false, "rewrite the Location, Content-Location and Refresh response headers which point at the destination to the listener. the paths are rewritten by the reverse of the --rewrite")
+	flags.BoolVar(&rewriteCookie, "rewrite-cookie", false, "rewrite the Domain of the destination and the Path of the Set-Cookie response headers for the listener. the paths are rewritten by the reverse of the --rewrite")
+
+	flags.StringArrayVar(&replaceBody, "replace-body", []string{}, "list for the literal substitutions of the response bodies. {listener} in the new is replaced with the URL of the listener (--replace-body https://api.example.com={listener})")
+	flags.StringArrayVar(&rewriteBody, "rewrite-body", []string{}, "list for the regexp substitutions of the response bodies. the replacement can refer the submatches such as $1 (--rewrite-body 'https?://([a-z]+)\\.example\\.com={listener}/$1')")
+	flags.StringSliceVar(&rewriteBodyTypes, "rewrite-body-type", config.DefaultRewriteBodyTypes, "list for the media types of the response bodies to rewrite. text/* matches any text types")
+	flags.Int64Var(&rewriteBodyLimit, "rewrite-body-limit", config.DefaultRewriteBodyLimit, "max size in bytes of the response body to rewrite. larger bodies are streamed unmodified")
+
+	flags.StringArrayVar(&responseHeaders, "response-header", []string{}, "list for the rules of the response headers in the form of [status=<codes>] [type=<media types>] <add|set|delete|replace> <name>[:<value>]. the value of the replace is <regexp>=<replacement> (--response-header 'delete Server' --response-header 'status=404 type=text/html set Cache-Control: no-store')")
+
+	flags.BoolVar(&cors, "cors", false, "answer the CORS preflight requests without forwarding them, and replace the Access-Control-Allow-* headers of the responses")
+	flags.StringSliceVar(&corsOrigins, "cors-origin", []string{}, "list for the allowed origins of the --cors. * or empty allows any origins (--cors-origin http://localhost:3000,http://127.0.0.1:3000)")
+	flags.StringSliceVar(&corsMethods, "cors-method", config.DefaultCORSMethods, "list for the allowed methods of the --cors")
+	flags.StringSliceVar(&corsHeaders, "cors-header", []string{}, "list for the allowed request headers of the --cors. empty reflects the Access-Control-Request-Headers")
+	flags.BoolVar(&corsCredentials, "cors-credentials", false, "allow the credentials such as the cookies in the --cors. requires the explicit --cors-origin or the --cors-reflect")
+	flags.DurationVar(&corsMaxAge, "cors-max-age", 0, "duration of caching the preflight result of the --cors. 0 omits the Access-Control-Max-Age")
+	flags.BoolVar(&corsReflect, "cors-reflect", false, "allow any origins and methods in the --cors by reflecting the Origin and the Access-Control-Request-Method. for the local development")
 }
 
 // RootCmd for CLI
 var RootCmd = &cobra.Command{
-	Use:   "hfwd <destination URL>",
+	Use:   "hfwd <destination URL>[,<destination URL>...]",
 	Short: "hfwd is a simple HTTP forward proxy",
 	Args: func(cmd *cobra.Command, args []string) error {
-		if len(args) == 0 {
-			return errors.New("requires at the <destination URL>")
+		if len(args) == 0 && len(routes) == 0 && configPath == "" {
+			return fmt.Errorf("requires at the <destination URL>")
 		}
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		dst, err := url.Parse(args[0])
-		if err != nil {
-			log.Fatalf("failed to parse the <desitination URL>: %v", err)
+		if code := run(cmd, args); code != exitOK {
+			os.Exit(code)
 		}
+	},
+}
 
-		params := config.Parameters{}
-		params.Verbose = verbose
-		params.RewritePaths = parseRewritePaths(rewritePaths)
+// Exit codes of the hfwd
+const (
+	exitOK     = 0
+	exitError  = 1 // failed to start or serve
+	exitForced = 2 // the grace period is exceeded before the in-flight requests complete
+)
 
-		params.Header = parseHeaders(headers)
-		params.Username = username
-		params.Password = password
+// run runs the hfwd proxy server until SIGINT or SIGTERM, and returns the exit code.
+// On the signal, the server stops accepting new connections and drains the in-flight requests up to the grace period.
+func run(cmd *cobra.Command, args []string) int {
+	srvConf, table, err := loadConfig(cmd, args)
+	if err != nil {
+		log.Printf("failed to setup configuration:\n%v", err)
+		return exitError
+	}
 
-		params.CACertPath = caCertPath
-		params.PKCS12Path = pkcs12Path
-		params.PKCS12Password = pkcs12Password
+	warnInsecure(nil, table)
+	router, err := hfwd.NewRouter(table)
+	if err != nil {
+		log.Printf("failed to setup the foward proxy: %v", err)
+		return exitError
+	}
+	handler := hfwd.NewSwapHandler(router)
+	defer handler.Close()
 
-		if err := params.Setup(); err != nil {
-			log.Fatalf("failed to setup configuration: %v", err)
+	ln, err := net.Listen("tcp", srvConf.Addr)
+	if err != nil {
+		log.Printf("failed to listening start at %v: %v", srvConf.Addr, err)
+		return exitError
+	}
+	defer ln.Close()
+
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: srvConf.ReadHeaderTimeout,
+		WriteTimeout:      srvConf.WriteTimeout,
+		IdleTimeout:       srvConf.IdleTimeout,
+	}
+	rl := newReloader(cmd, args, handler, &srvConf, table)
+	if srvConf.Enabled() {
+		srv.TLSConfig = rl.tlsConfig()
+	}
+	// HTTP/2 over the TLS is negotiated by the ALPN, and the h2c is served by the handler
+	h2s := &http2.Server{IdleTimeout: srvConf.IdleTimeout}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		log.Printf("failed to setup HTTP/2: %v", err)
+		return exitError
+	}
+	if srvConf.H2C {
+		srv.Handler = h2c.NewHandler(handler, h2s)
+	}
+	if srvConf.Enabled() {
+		ln = tls.NewListener(ln, srv.TLSConfig)
+		log.Printf("hfwd listening on https://%v", srvConf.Addr)
+	} else {
+		log.Printf("hfwd listening on %v", srvConf.Addr)
+	}
+	go rl.run(srvConf.ReloadInterval)
+	defer rl.stop()
+
+	servers := []*http.Server{srv}
+	errc := make(chan error, 2)
+	if srvConf.AdminAddr != "" {
+		admin := &http.Server{Addr: srvConf.AdminAddr, Handler: hfwd.NewAdminHandler(handler)}
+		servers = append(servers, admin)
+		go func() {
+			log.Printf("hfwd admin endpoint listening on %v", admin.Addr)
+			if err := admin.ListenAndServe(); err != http.ErrServerClosed {
+				log.Printf("hfwd admin endpoint stopped: %v", err)
+			}
+		}()
+	}
+	go func() { errc <- srv.Serve(ln) }()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errc:
+		log.Printf("hfwd stopped: %v", err)
+		return exitError
+	case s := <-sig:
+		log.Printf("hfwd received %v. shutting down with the grace period %v", s, srvConf.GracePeriod)
+	}
+	if !shutdown(servers, srvConf.GracePeriod) {
+		log.Printf("hfwd exceeded the grace period. the remaining connections are closed")
+		return exitForced
+	}
+	log.Printf("hfwd shut down gracefully")
+	return exitOK
+}
+
+// shutdown stops the servers from accepting new connections, and waits for the in-flight requests up to the grace period.
+// shutdown reports whether all requests complete within the grace period.
+func shutdown(servers []*http.Server, grace time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	ok := true
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			ok = false
+			srv.Close()
 		}
+	}
+	return ok
+}
 
-		handler, err := hfwd.NewHandler(dst, &params)
+// loadConfig loads the configuration of the listener and the routing table from the configuration file and the flags.
+// flags take precedence over the configuration file.
+// The <destination URL> becomes the default route which matches any requests.
+func loadConfig(cmd *cobra.Command, args []string) (config.Server, []*config.Route, error) {
+	file := &config.File{}
+	if configPath != "" {
+		f, err := config.LoadFile(configPath)
 		if err != nil {
-			log.Fatalf("failed to setup the foward proxy: %v", err)
+			return config.Server{}, nil, err
 		}
+		file = f
+	}
+	// fromFile reports whether the value of the named flag should be taken from the configuration file
+	fromFile := func(name string) bool {
+		return configPath != "" && !cmd.Flags().Changed(name)
+	}
+
+	errs := errors.NewMultiLine()
 
-		ln, err := net.Listen("tcp", lnAddr)
+	srvConf := loadServer(file, fromFile)
+	params, err := loadParameters(file, fromFile)
+	errs.AddIfErr(err)
+
+	// each route inherits the copy of the top-level parameters, so that the setup of a route does not leak to the others
+	var rr []*config.Route
+	for _, fr := range file.Routes {
+		r := fr.Route()
+		r.Inherit(&params)
+		rr = append(rr, r)
+	}
+	for _, s := range routes {
+		r, err := parseRoute(s)
 		if err != nil {
-			log.Fatalf("failed to listening start at %v: %v", lnAddr, err)
+			errs.Add(err)
+			continue
 		}
-		defer ln.Close()
+		r.Inherit(&params)
+		rr = append(rr, r)
+	}
+	dst := file.Destination
+	if len(args) > 0 {
+		dst = args[0]
+	}
+	if dst != "" {
+		r := &config.Route{Destination: dst}
+		r.Inherit(&params)
+		rr = append(rr, r)
+	}
+	if len(rr) == 0 {
+		errs.Add(fmt.Errorf("requires at the <destination URL>"))
+	}
 
-		log.Printf("hfwd listening on %v", lnAddr)
-		out := http.Serve(ln, handler)
-		log.Println(out)
-	},
+	errs.AddIfErr(srvConf.Setup())
+	for _, r := range rr {
+		errs.AddIfErr(r.Setup())
+	}
+	if errs.Len() > 0 {
+		return config.Server{}, nil, errs
+	}
+	return srvConf, rr, nil
+}
+
+// loadServer loads the configuration of the listener from the configuration file and the flags.
+// The returned Server is not setup yet.
+func loadServer(file *config.File, fromFile func(string) bool) config.Server {
+	fileServer := file.Server()
+	srvConf := config.Server{}
+	srvConf.Addr = stringFlag("listen", lnAddr, fileServer.Addr, fromFile)
+	srvConf.AdminAddr = stringFlag("admin", adminAddr, fileServer.AdminAddr, fromFile)
+	srvConf.ReadHeaderTimeout = durationFlag("read-header-timeout", readHeaderTimeout, fileServer.ReadHeaderTimeout, fromFile)
+	srvConf.WriteTimeout = durationFlag("write-timeout", writeTimeout, fileServer.WriteTimeout, fromFile)
+	srvConf.IdleTimeout = durationFlag("idle-timeout", idleTimeout, fileServer.IdleTimeout, fromFile)
+
+	srvConf.CertPaths = stringsFlag("tls-cert", tlsCertPaths, fileServer.CertPaths, fromFile)
+	srvConf.KeyPaths = stringsFlag("tls-key", tlsKeyPaths, fileServer.KeyPaths, fromFile)
+	srvConf.PKCS12Paths = stringsFlag("tls-pkcs12", tlsPKCS12Paths, fileServer.PKCS12Paths, fromFile)
+	srvConf.PKCS12Password = stringFlag("tls-pkcs12-password", tlsPKCS12Password, fileServer.PKCS12Password, fromFile)
+	srvConf.LocalCA = tlsLocalCA
+	if fromFile("tls-local-ca") {
+		srvConf.LocalCA = fileServer.LocalCA
+	}
+	srvConf.LocalCANames = stringsFlag("tls-local-ca-name", tlsLocalCANames, fileServer.LocalCANames, fromFile)
+	srvConf.StateDir = stringFlag("state-dir", stateDir, fileServer.StateDir, fromFile)
+	srvConf.ClientCAPath = stringFlag("client-ca", clientCAPath, fileServer.ClientCAPath, fromFile)
+	srvConf.ClientAuth = stringFlag("client-auth", clientAuth, fileServer.ClientAuth, fromFile)
+	srvConf.H2C = listenH2C
+	if fromFile("h2c") {
+		srvConf.H2C = fileServer.H2C
+	}
+
+	srvConf.GracePeriod = durationFlag("grace-period", gracePeriod, fileServer.GracePeriod, fromFile)
+	srvConf.ReloadInterval = durationFlag("reload-interval", reloadInterval, fileServer.ReloadInterval, fromFile)
+	return srvConf
+}
+
+// loadParameters loads the configuration parameters from the configuration file and the flags.
+// The returned Parameters is not setup yet.
+func loadParameters(file *config.File, fromFile func(string) bool) (config.Parameters, error) {
+	errs := errors.NewMultiLine()
+	fileParams := file.FileParameters.Parameters()
+
+	params := config.Parameters{}
+	params.Verbose = verbose
+	if fromFile("verbose") {
+		params.Verbose = file.Verbose
+	}
+
+	var err error
+	params.RewritePaths, err = parseRewritePaths(rewritePaths)
+	errs.AddIfErr(err)
+	if fromFile("rewrite") && len(fileParams.RewritePaths) > 0 {
+		params.RewritePaths = fileParams.RewritePaths
+	}
+
+	params.Header, err = parseHeaders(headers)
+	errs.AddIfErr(err)
+	if fromFile("header") && len(fileParams.Header) > 0 {
+		params.Header = fileParams.Header
+	}
+	params.RequestHeaderRules, err = parseHeaderRules("request-header", requestHeaders)
+	errs.AddIfErr(err)
+	if fromFile("request-header") && len(fileParams.RequestHeaderRules) > 0 {
+		params.RequestHeaderRules = fileParams.RequestHeaderRules
+	}
+	params.Username = stringFlag("username", username, fileParams.Username, fromFile)
+	params.Password = stringFlag("password", password, fileParams.Password, fromFile)
+
+	params.CACertPath = stringFlag("ca-cert", caCertPath, fileParams.CACertPath, fromFile)
+	params.PKCS12Path = stringFlag("pkcs12", pkcs12Path, fileParams.PKCS12Path, fromFile)
+	params.PKCS12Password = stringFlag("pkcs12-password", pkcs12Password, fileParams.PKCS12Password, fromFile)
+	params.CertPath = stringFlag("client-cert", clientCertPath, fileParams.CertPath, fromFile)
+	params.KeyPath = stringFlag("client-key", clientKeyPath, fileParams.KeyPath, fromFile)
+	params.KeyPassword = stringFlag("client-key-password", clientKeyPass, fileParams.KeyPassword, fromFile)
+	params.CAOnly = caCertOnly
+	if fromFile("ca-cert-only") {
+		params.CAOnly = fileParams.CAOnly
+	}
+	params.MinVersion = stringFlag("min-tls-version", minTLSVersion, fileParams.MinVersion, fromFile)
+	params.MaxVersion = stringFlag("max-tls-version", maxTLSVersion, fileParams.MaxVersion, fromFile)
+	params.CipherSuites = stringsFlag("cipher-suites", cipherSuites, fileParams.CipherSuites, fromFile)
+	params.CurvePreferences = stringsFlag("curves", curves, fileParams.CurvePreferences, fromFile)
+	params.ServerName = stringFlag("server-name", serverName, fileParams.ServerName, fromFile)
+	params.Insecure = insecure
+	if fromFile("insecure") {
+		params.Insecure = fileParams.Insecure
+	}
+
+	params.Balance = stringFlag("balance", balance, fileParams.Balance, fromFile)
+	params.HealthCheckPath = stringFlag("health-check-path", healthCheckPath, fileParams.HealthCheckPath, fromFile)
+	params.HealthCheckInterval = durationFlag("health-check-interval", healthCheckInterval, fileParams.HealthCheckInterval, fromFile)
+	params.HealthCheckTimeout = durationFlag("health-check-timeout", healthCheckTimeout, fileParams.HealthCheckTimeout, fromFile)
+	params.MaxFails = intFlag("max-fails", maxFails, fileParams.MaxFails, fromFile)
+	params.FailCooldown = durationFlag("fail-cooldown", failCooldown, fileParams.FailCooldown, fromFile)
+	params.H2C = destinationH2C
+	if fromFile("destination-h2c") {
+		params.H2C = fileParams.H2C
+	}
+
+	params.Retries = intFlag("retries", retries, fileParams.Retries, fromFile)
+	params.Backoff = durationFlag("retry-backoff", retryBackoff, fileParams.Backoff, fromFile)
+	params.MaxBackoff = durationFlag("retry-max-backoff", retryMaxBackoff, fileParams.MaxBackoff, fromFile)
+	params.StatusCodes = retryStatus
+	if fromFile("retry-status") && len(fileParams.StatusCodes) > 0 {
+		params.StatusCodes = fileParams.StatusCodes
+	}
+	params.RetryNonIdempotent = retryNonIdempotent
+	if fromFile("retry-non-idempotent") {
+		params.RetryNonIdempotent = fileParams.RetryNonIdempotent
+	}
+	params.BodyLimit = retryBodyLimit
+	if fromFile("retry-body-limit") && fileParams.BodyLimit != 0 {
+		params.BodyLimit = fileParams.BodyLimit
+	}
+	params.Budget = durationFlag("retry-budget", retryBudget, fileParams.Budget, fromFile)
+
+	params.ErrorFormat = stringFlag("error-format", errorFormat, fileParams.ErrorFormat, fromFile)
+
+	params.DialTimeout = durationFlag("dial-timeout", dialTimeout, fileParams.DialTimeout, fromFile)
+	params.TLSHandshakeTimeout = durationFlag("tls-handshake-timeout", tlsHandshakeTimeout, fileParams.TLSHandshakeTimeout, fromFile)
+	params.ResponseHeaderTimeout = durationFlag("response-header-timeout", responseHeaderTimeout, fileParams.ResponseHeaderTimeout, fromFile)
+	params.IdleConnTimeout = durationFlag("idle-conn-timeout", idleConnTimeout, fileParams.IdleConnTimeout, fromFile)
+	params.RequestTimeout = durationFlag("request-timeout", requestTimeout, fileParams.RequestTimeout, fromFile)
+
+	params.SubjectHeader = stringFlag("client-cert-subject-header", clientCertSubjectHeader, fileParams.SubjectHeader, fromFile)
+	params.SANHeader = stringFlag("client-cert-san-header", clientCertSANHeader, fileParams.SANHeader, fromFile)
+	params.CertHeader = stringFlag("client-cert-header", clientCertHeader, fileParams.CertHeader, fromFile)
+
+	params.FlushInterval = durationFlag("flush-interval", flushInterval, fileParams.FlushInterval, fromFile)
+
+	params.XForwarded = stringFlag("x-forwarded", xForwarded, fileParams.XForwarded, fromFile)
+	params.Forwarded = stringFlag("forwarded", forwarded, fileParams.Forwarded, fromFile)
+	params.Via = stringFlag("via", via, fileParams.Via, fromFile)
+	params.TrustedProxies = stringsFlag("trusted-proxy", trustedProxies, fileParams.TrustedProxies, fromFile)
+
+	params.RewriteLocation = rewriteLocation
+	if fromFile("rewrite-location") {
+		params.RewriteLocation = fileParams.RewriteLocation
+	}
+	params.RewriteCookie = rewriteCookie
+	if fromFile("rewrite-cookie") {
+		params.RewriteCookie = fileParams.RewriteCookie
+	}
+
+	params.ReplaceBody, err = parseBodyRules("replace-body", replaceBody)
+	errs.AddIfErr(err)
+	if fromFile("replace-body") && len(fileParams.ReplaceBody) > 0 {
+		params.ReplaceBody = fileParams.ReplaceBody
+	}
+	params.RewriteBody, err = parseBodyRules("rewrite-body", rewriteBody)
+	errs.AddIfErr(err)
+	if fromFile("rewrite-body") && len(fileParams.RewriteBody) > 0 {
+		params.RewriteBody = fileParams.RewriteBody
+	}
+	params.RewriteBodyTypes = stringsFlag("rewrite-body-type", rewriteBodyTypes, fileParams.RewriteBodyTypes, fromFile)
+	params.RewriteBodyLimit = rewriteBodyLimit
+	if fromFile("rewrite-body-limit") && fileParams.RewriteBodyLimit != 0 {
+		params.RewriteBodyLimit = fileParams.RewriteBodyLimit
+	}
+
+	params.ResponseHeaderRules, err = parseHeaderRules("response-header", responseHeaders)
+	errs.AddIfErr(err)
+	if fromFile("response-header") && len(fileParams.ResponseHeaderRules) > 0 {
+		params.ResponseHeaderRules = fileParams.ResponseHeaderRules
+	}
+
+	params.EnableCORS = cors
+	if fromFile("cors") {
+		params.EnableCORS = fileParams.EnableCORS
+	}
+	params.CORSOrigins = stringsFlag("cors-origin", corsOrigins, fileParams.CORSOrigins, fromFile)
+	params.CORSMethods = stringsFlag("cors-method", corsMethods, fileParams.CORSMethods, fromFile)
+	params.CORSHeaders = stringsFlag("cors-header", corsHeaders, fileParams.CORSHeaders, fromFile)
+	params.CORSCredentials = corsCredentials
+	if fromFile("cors-credentials") {
+		params.CORSCredentials = fileParams.CORSCredentials
+	}
+	params.CORSMaxAge = durationFlag("cors-max-age", corsMaxAge, fileParams.CORSMaxAge, fromFile)
+	params.CORSReflect = corsReflect
+	if fromFile("cors-reflect") {
+		params.CORSReflect = fileParams.CORSReflect
+	}
+
+	if errs.Len() > 0 {
+		return params, errs
+	}
+	return params, nil
+}
+
+// stringFlag returns the flag value, or the configuration file value if the flag is not given
+func stringFlag(name, flagValue, fileValue string, fromFile func(string) bool) string {
+	if fromFile(name) && fileValue != "" {
+		return fileValue
+	}
+	return flagValue
 }
 
-func parseRewritePaths(rewritePaths []string) map[string]string {
+// stringsFlag returns the flag value, or the configuration file value if the flag is not given
+func stringsFlag(name string, flagValue, fileValue []string, fromFile func(string) bool) []string {
+	if fromFile(name) && len(fileValue) > 0 {
+		return fileValue
+	}
+	return flagValue
+}
+
+// durationFlag returns the flag value, or the configuration file value if the flag is not given
+func durationFlag(name string, flagValue, fileValue time.Duration, fromFile func(string) bool) time.Duration {
+	if fromFile(name) && fileValue != 0 {
+		return fileValue
+	}
+	return flagValue
+}
+
+// intFlag returns the flag value, or the configuration file value if the flag is not given
+func intFlag(name string, flagValue, fileValue int, fromFile func(string) bool) int {
+	if fromFile(name) && fileValue != 0 {
+		return fileValue
+	}
+	return flagValue
+}
+
+func parseRewritePaths(rewritePaths []string) (map[string]string, error) {
 	m := make(map[string]string, len(rewritePaths))
 	for _, p := range rewritePaths {
 		sp := strings.SplitN(p, ":", 2)
 		if len(sp) < 2 {
-			log.Fatalln("-r --rewrite must be <old>:<new>")
-			continue
+			return nil, fmt.Errorf("-r --rewrite must be <old>:<new>, got %q", p)
 		}
 		m[strings.TrimSpace(sp[0])] = strings.TrimSpace(sp[1])
 	}
-	return m
+	return m, nil
+}
+
+// parseBodyRules parses the substitution rules of the response body in the form of <old>=<new>
+func parseBodyRules(name string, rules []string) (map[string]string, error) {
+	m := make(map[string]string, len(rules))
+	for _, r := range rules {
+		sp := strings.SplitN(r, "=", 2)
+		if len(sp) < 2 || sp[0] == "" {
+			return nil, fmt.Errorf("--%s must be <old>=<new>, got %q", name, r)
+		}
+		m[sp[0]] = sp[1]
+	}
+	return m, nil
+}
+
+// parseHeaderRules parses the header rules in the form of [status=<codes>] [type=<media types>] <action> <name>[:<value>].
+// The value of the replace action is in the form of <regexp>=<replacement>.
+func parseHeaderRules(name string, rules []string) ([]config.HeaderRule, error) {
+	hrs := make([]config.HeaderRule, 0, len(rules))
+	for _, rule := range rules {
+		invalid := fmt.Errorf("--%s must be [status=<codes>] [type=<media types>] <action> <name>[:<value>], got %q", name, rule)
+		hr := config.HeaderRule{}
+		rest := strings.TrimSpace(rule)
+		for hr.Action == "" {
+			sp := strings.SplitN(rest, " ", 2)
+			if len(sp) < 2 {
+				return nil, invalid
+			}
+			token := sp[0]
+			rest = strings.TrimSpace(sp[1])
+			switch {
+			case strings.HasPrefix(token, "status="):
+				for _, c := range strings.Split(strings.TrimPrefix(token, "status="), ",") {
+					code, err := strconv.Atoi(c)
+					if err != nil {
+						return nil, invalid
+					}
+					hr.StatusCodes = append(hr.StatusCodes, code)
+				}
+			case strings.HasPrefix(token, "type="):
+				hr.ContentTypes = strings.Split(strings.TrimPrefix(token, "type="), ",")
+			default:
+				hr.Action = token
+			}
+		}
+		sp := strings.SplitN(rest, ":", 2)
+		hr.Name = strings.TrimSpace(sp[0])
+		if len(sp) == 2 {
+			hr.Value = strings.TrimSpace(sp[1])
+		}
+		if hr.Action == config.HeaderActionReplace {
+			sp := strings.SplitN(hr.Value, "=", 2)
+			if len(sp) < 2 {
+				return nil, fmt.Errorf("--%s replace must be replace <name>:<regexp>=<replacement>, got %q", name, rule)
+			}
+			hr.Pattern, hr.Value = sp[0], sp[1]
+		}
+		hrs = append(hrs, hr)
+	}
+	return hrs, nil
 }
 
-func parseHeaders(headers []string) http.Header {
+func parseHeaders(headers []string) (http.Header, error) {
 	hh := make(http.Header, len(headers))
 	for _, h := range headers {
 		sp := strings.SplitN(h, ":", 2)
 		if len(sp) < 2 {
-			log.Fatalln("-H --header must be <name>:<value>")
-			continue
+			return nil, fmt.Errorf("-H --header must be <name>:<value>, got %q", h)
 		}
 		hh.Add(strings.TrimSpace(sp[0]), strings.TrimSpace(sp[1]))
 	}
-	return hh
+	return hh, nil
+}
+
+// parseRoute parses the route in the form of <host><path prefix>=<destination URL>
+func parseRoute(route string) (*config.Route, error) {
+	sp := strings.SplitN(route, "=", 2)
+	if len(sp) < 2 || strings.TrimSpace(sp[0]) == "" {
+		return nil, fmt.Errorf("--route must be <host><path prefix>=<destination URL>, got %q", route)
+	}
+	r := config.Route{Destination: strings.TrimSpace(sp[1])}
+	match := strings.TrimSpace(sp[0])
+	if i := strings.Index(match, "/"); i >= 0 {
+		r.Host, r.PathPrefix = match[:i], match[i:]
+	} else {
+		r.Host = match
+	}
+	return &r, nil
 }
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadConfig_Routes(t *testing.T) {
+	defer func(old []string) { routes = old }(routes)
+	defer func(old []string) { headers = old }(headers)
+	defer func(old string) { username = old }(username)
+	routes = []string{"/a=http://a.example.com", "/b=http://b.example.com"}
+	headers = []string{"X-Test:test"}
+	username = "user"
+
+	withReloader(t, `
+destination: http://example.com
+retries: 2
+dial-timeout: 3s
+routes:
+  - path-prefix: /c
+    destination: http://c.example.com
+    retries: 1
+`, func(rl *reloader, proxyURL string) {
+		_, table, err := loadConfig(RootCmd, nil)
+		if err != nil {
+			t.Fatalf("failed to load the configuration: %v", err)
+		}
+		if g, w := len(table), 4; g != w {
+			t.Fatalf("len(table) got %v, want %v", g, w)
+		}
+		for _, r := range table {
+			if g, w := r.Header.Get("X-Test"), "test"; g != w {
+				t.Errorf("%v: X-Test got %v, want %v", r.Name(), g, w)
+			}
+			if g, w := r.DialTimeout, 3*time.Second; g != w {
+				t.Errorf("%v: DialTimeout got %v, want %v", r.Name(), g, w)
+			}
+			want := 2
+			if r.PathPrefix == "/c" {
+				want = 1
+			}
+			if g := r.Retries; g != want {
+				t.Errorf("%v: Retries got %v, want %v", r.Name(), g, want)
+			}
+		}
+		// the routes do not share the header
+		table[1].Header.Set("X-Leak", "leak")
+		for i, r := range table {
+			if g := r.Header.Get("X-Leak"); i != 1 && g != "" {
+				t.Errorf("%v: X-Leak got %v, want blank", r.Name(), g)
+			}
+		}
+	})
+}
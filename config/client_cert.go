@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ClientCertHeaders is configuration parameters for the headers which forward the verified client certificate of the listener.
+// The headers of the same names given by the client are always removed.
+type ClientCertHeaders struct {
+	SubjectHeader string // header name for the subject of the client certificate or blank. blank disables the header
+	SANHeader     string // header name for the subject alternative names of the client certificate or blank. blank disables the header
+	CertHeader    string // header name for the URL encoded PEM of the client certificate or blank. blank disables the header
+}
+
+// setup configuration given parameters
+func (c *ClientCertHeaders) setup() error {
+	c.SubjectHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.SubjectHeader))
+	c.SANHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.SANHeader))
+	c.CertHeader = http.CanonicalHeaderKey(strings.TrimSpace(c.CertHeader))
+	return nil
+}
+
+// ClientCertHeaderNames returns the names of the enabled headers
+func (c *ClientCertHeaders) ClientCertHeaderNames() []string {
+	var names []string
+	for _, name := range []string{c.SubjectHeader, c.SANHeader, c.CertHeader} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (c *ClientCertHeaders) String() string {
+	b := strings.Builder{}
+	if c == nil {
+		return b.String()
+	}
+	if names := c.ClientCertHeaderNames(); len(names) > 0 {
+		b.WriteString(fmt.Sprintf("ClientCertHeaders: %s\n", strings.Join(names, ", ")))
+	}
+	return b.String()
+}
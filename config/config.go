@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/kei2100/h-fwd/errors"
 )
@@ -11,6 +12,17 @@ type Parameters struct {
 	URL
 	Headers
 	TLSClient
+	Upstream
+	Retry
+	ErrorResponse
+	Timeouts
+	ClientCertHeaders
+	Streaming
+	ForwardedHeaders
+	ReverseRewrite
+	ResponseBody
+	ResponseHeaders
+	CORS
 	Verbose bool
 }
 
@@ -20,16 +32,76 @@ func (p *Parameters) Setup() error {
 	errs.AddIfErr(p.URL.setup())
 	errs.AddIfErr(p.Headers.setup())
 	errs.AddIfErr(p.TLSClient.setup())
+	errs.AddIfErr(p.Upstream.setup())
+	errs.AddIfErr(p.Retry.setup())
+	errs.AddIfErr(p.ErrorResponse.setup())
+	errs.AddIfErr(p.Timeouts.setup())
+	errs.AddIfErr(p.ClientCertHeaders.setup())
+	errs.AddIfErr(p.Streaming.setup())
+	errs.AddIfErr(p.ForwardedHeaders.setup())
+	errs.AddIfErr(p.ReverseRewrite.setup())
+	errs.AddIfErr(p.ResponseBody.setup())
+	errs.AddIfErr(p.ResponseHeaders.setup())
+	errs.AddIfErr(p.CORS.setup())
 	if errs.Len() > 0 {
 		return errs
 	}
 	return nil
 }
 
+// Inherit sets the parameters of the parent to the zero fields of this, so that a route inherits the top-level parameters.
+// The maps and the slices are copied, so that the setup of this does not modify the parent.
+// Since the false is the zero, the bool of this can not turn off the true of the parent.
+// Inherit must be called before the setup.
+func (p *Parameters) Inherit(parent *Parameters) {
+	inherit(reflect.ValueOf(p).Elem(), reflect.ValueOf(parent).Elem())
+}
+
+// inherit sets the fields of the src to the zero fields of the dst, and walks into the embedded structs
+func inherit(dst, src reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		f := dst.Field(i)
+		switch {
+		case !f.CanSet():
+			// the unexported fields are filled by the setup
+		case dst.Type().Field(i).Anonymous && f.Kind() == reflect.Struct:
+			inherit(f, src.Field(i))
+		case isZero(f):
+			f.Set(copyValue(src.Field(i)))
+		}
+	}
+}
+
+// isZero reports whether the v is the zero value. the empty maps and slices are also zero
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// copyValue returns the shallow copy of the v if it is the map or the slice, otherwise the v
+func copyValue(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.Map && !v.IsNil():
+		m := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			m.SetMapIndex(k, v.MapIndex(k))
+		}
+		return m
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		return reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v)
+	}
+	return v
+}
+
 // String returns string representation of this configuration. useful for debugging.
 func (p *Parameters) String() string {
 	if p == nil {
 		return ""
 	}
-	return fmt.Sprintf("%s%s%s", p.URL.String(), p.Headers.String(), p.TLSClient.String())
+	return fmt.Sprintf("%s%s%s%s%s%s%s%s%s%s%s%s%s%s", p.URL.String(), p.Headers.String(), p.TLSClient.String(),
+		p.Upstream.String(), p.Retry.String(), p.ErrorResponse.String(), p.Timeouts.String(), p.ClientCertHeaders.String(),
+		p.Streaming.String(), p.ForwardedHeaders.String(), p.ReverseRewrite.String(), p.ResponseBody.String(), p.ResponseHeaders.String(), p.CORS.String())
 }
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParameters_Inherit(t *testing.T) {
+	parent := Parameters{}
+	parent.Header = http.Header{"X-Parent": {"parent"}}
+	parent.Username = "user"
+	parent.Retries = 2
+	parent.StatusCodes = []int{503}
+	parent.DialTimeout = time.Second
+	parent.Insecure = true
+	parent.Verbose = true
+
+	p := Parameters{}
+	p.Retries = 3
+	p.DialTimeout = 0
+	p.Inherit(&parent)
+
+	if g, w := p.Header, parent.Header; !reflect.DeepEqual(g, w) {
+		t.Errorf("Header got %v, want %v", g, w)
+	}
+	if g, w := p.Retries, 3; g != w {
+		t.Errorf("Retries got %v, want the own %v", g, w)
+	}
+	if g, w := p.StatusCodes, []int{503}; !reflect.DeepEqual(g, w) {
+		t.Errorf("StatusCodes got %v, want %v", g, w)
+	}
+	if g, w := p.DialTimeout, time.Second; g != w {
+		t.Errorf("DialTimeout got %v, want %v", g, w)
+	}
+	if !p.Insecure || !p.Verbose {
+		t.Errorf("Insecure got %v and Verbose got %v, want true", p.Insecure, p.Verbose)
+	}
+
+	// the setup of the child does not modify the parent
+	if err := p.Setup(); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+	if g := parent.Header.Get("Authorization"); g != "" {
+		t.Errorf("Authorization of the parent got %v, want blank", g)
+	}
+	p.StatusCodes[0] = 502
+	if g, w := parent.StatusCodes[0], 503; g != w {
+		t.Errorf("StatusCodes of the parent got %v, want %v", g, w)
+	}
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMethods is the default value of the CORS.CORSMethods
+var DefaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// CORS is configuration parameters for the CORS mode.
+// In the CORS mode, hfwd answers the preflight requests without forwarding them,
+// and replaces the Access-Control-* headers of the responses with its own.
+type CORS struct {
+	EnableCORS      bool
+	CORSOrigins     []string      // allowed origins such as "http://localhost:3000". "*" or empty allows any origins
+	CORSMethods     []string      // allowed methods of the preflight. empty means DefaultCORSMethods
+	CORSHeaders     []string      // allowed request headers of the preflight. empty reflects the Access-Control-Request-Headers
+	CORSCredentials bool          // allows the credentials such as the cookies. requires the explicit CORSOrigins or the CORSReflect
+	CORSMaxAge      time.Duration // duration of caching the preflight result or 0. 0 omits the Access-Control-Max-Age
+	CORSReflect     bool          // allows any origins and methods by reflecting the Origin and the Access-Control-Request-Method
+}
+
+// setup configuration given parameters
+func (c *CORS) setup() error {
+	if c.CORSMaxAge < 0 {
+		return fmt.Errorf("config: cors max age must not be negative")
+	}
+	if len(c.CORSMethods) == 0 {
+		c.CORSMethods = DefaultCORSMethods
+	}
+	methods := make([]string, len(c.CORSMethods))
+	for i, m := range c.CORSMethods {
+		methods[i] = strings.ToUpper(strings.TrimSpace(m))
+	}
+	c.CORSMethods = methods
+	headers := make([]string, len(c.CORSHeaders))
+	for i, h := range c.CORSHeaders {
+		headers[i] = http.CanonicalHeaderKey(strings.TrimSpace(h))
+	}
+	c.CORSHeaders = headers
+	origins := make([]string, len(c.CORSOrigins))
+	for i, o := range c.CORSOrigins {
+		origins[i] = strings.TrimSuffix(strings.TrimSpace(o), "/")
+	}
+	c.CORSOrigins = origins
+	if c.CORSCredentials && !c.CORSReflect && c.anyOrigin() {
+		// the credentials of any sites must not be allowed by mistake
+		return fmt.Errorf("config: cors credentials requires the explicit cors origins or the cors reflect")
+	}
+	return nil
+}
+
+// anyOrigin reports whether the CORSOrigins allows any origins
+func (c *CORS) anyOrigin() bool {
+	if len(c.CORSOrigins) == 0 {
+		return true
+	}
+	for _, o := range c.CORSOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// VaryOrigin reports whether the Access-Control-Allow-Origin varies by the Origin of the request
+func (c *CORS) VaryOrigin() bool {
+	return c.CORSReflect || !c.anyOrigin()
+}
+
+// AllowOrigin returns the value of the Access-Control-Allow-Origin for the origin, or blank if the origin is not allowed
+func (c *CORS) AllowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, o := range c.CORSOrigins {
+		if strings.EqualFold(o, origin) {
+			return origin
+		}
+	}
+	switch {
+	case c.CORSReflect:
+		return origin
+	case c.anyOrigin() && !c.CORSCredentials:
+		// "*" is not allowed with the credentials
+		return "*"
+	}
+	return ""
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (c *CORS) String() string {
+	b := strings.Builder{}
+	if c == nil || !c.EnableCORS {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("CORSOrigins: %v (reflect %v)\n", c.CORSOrigins, c.CORSReflect))
+	b.WriteString(fmt.Sprintf("CORSMethods: %v\n", c.CORSMethods))
+	b.WriteString(fmt.Sprintf("CORSHeaders: %v\n", c.CORSHeaders))
+	b.WriteString(fmt.Sprintf("CORSCredentials: %v\n", c.CORSCredentials))
+	b.WriteString(fmt.Sprintf("CORSMaxAge: %v\n", c.CORSMaxAge))
+	return b.String()
+}
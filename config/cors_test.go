@@ -0,0 +1,51 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+	tt := []struct {
+		cors    CORS
+		wantErr bool
+	}{
+		{cors: CORS{}},
+		{cors: CORS{EnableCORS: true, CORSOrigins: []string{"http://localhost:3000"}, CORSMaxAge: time.Hour}},
+		{cors: CORS{EnableCORS: true, CORSMaxAge: -1}, wantErr: true},
+		{cors: CORS{EnableCORS: true, CORSCredentials: true}, wantErr: true},
+		{cors: CORS{EnableCORS: true, CORSOrigins: []string{"*"}, CORSCredentials: true}, wantErr: true},
+		{cors: CORS{EnableCORS: true, CORSCredentials: true, CORSReflect: true}},
+	}
+	for i, te := range tt {
+		err := te.cors.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestCORS_AllowOrigin(t *testing.T) {
+	tt := []struct {
+		cors   CORS
+		origin string
+		want   string
+	}{
+		{cors: CORS{}, origin: "http://localhost:3000", want: "*"},
+		{cors: CORS{}, origin: "", want: ""},
+		{cors: CORS{CORSOrigins: []string{"*"}}, origin: "http://localhost:3000", want: "*"},
+		{cors: CORS{CORSOrigins: []string{"http://localhost:3000"}, CORSCredentials: true}, origin: "http://localhost:3000", want: "http://localhost:3000"},
+		{cors: CORS{CORSCredentials: true, CORSReflect: true}, origin: "http://localhost:3000", want: "http://localhost:3000"},
+		{cors: CORS{CORSOrigins: []string{"http://localhost:3000/"}}, origin: "http://localhost:3000", want: "http://localhost:3000"},
+		{cors: CORS{CORSOrigins: []string{"http://localhost:3000"}}, origin: "http://evil.example.com", want: ""},
+		{cors: CORS{CORSOrigins: []string{"http://localhost:3000"}, CORSReflect: true}, origin: "http://evil.example.com", want: "http://evil.example.com"},
+	}
+	for i, te := range tt {
+		if err := te.cors.setup(); err != nil {
+			t.Fatalf("%v: failed to setup: %v", i, err)
+		}
+		if g, w := te.cors.AllowOrigin(te.origin), te.want; g != w {
+			t.Errorf("%v: AllowOrigin(%q) got %v, want %v", i, te.origin, g, w)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Formats of the error response body
+const (
+	ErrorFormatNone = "none"
+	ErrorFormatText = "text"
+	ErrorFormatJSON = "json"
+)
+
+// ErrorResponse is configuration parameters for the error response when the forwarding fails
+type ErrorResponse struct {
+	ErrorFormat string // format of the error response body or blank. blank means text
+}
+
+// setup configuration given parameters
+func (e *ErrorResponse) setup() error {
+	switch e.ErrorFormat {
+	case "":
+		e.ErrorFormat = ErrorFormatText
+	case ErrorFormatNone, ErrorFormatText, ErrorFormatJSON:
+	default:
+		return fmt.Errorf("config: unknown error format %q. must be %v, %v or %v",
+			e.ErrorFormat, ErrorFormatNone, ErrorFormatText, ErrorFormatJSON)
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (e *ErrorResponse) String() string {
+	b := strings.Builder{}
+	if e == nil {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("ErrorFormat: %s\n", e.ErrorFormat))
+	return b.String()
+}
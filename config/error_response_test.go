@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorResponse(t *testing.T) {
+	tt := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{format: "", want: ErrorFormatText},
+		{format: ErrorFormatNone, want: ErrorFormatNone},
+		{format: ErrorFormatJSON, want: ErrorFormatJSON},
+		{format: "xml", wantErr: true},
+	}
+	for _, te := range tt {
+		e := ErrorResponse{ErrorFormat: te.format}
+		err := e.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", te.format, err, w)
+			continue
+		}
+		if err == nil && e.ErrorFormat != te.want {
+			t.Errorf("%v: ErrorFormat got %v, want %v", te.format, e.ErrorFormat, te.want)
+		}
+	}
+}
+
+func TestErrorResponse_String(t *testing.T) {
+	e := &ErrorResponse{ErrorFormat: ErrorFormatJSON}
+	got := fmt.Sprintf("%v", e)
+	want := "ErrorFormat: json\n"
+	if g, w := got, want; g != w {
+		t.Errorf("String() got %v, want %v", g, w)
+	}
+}
@@ -0,0 +1,346 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/kei2100/h-fwd/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the declarative configuration file for the hfwd.
+// Each key corresponds to the command line flag of the same name.
+type File struct {
+	Destination    string `yaml:"destination" toml:"destination"`
+	Listen         string `yaml:"listen" toml:"listen"`
+	Admin          string `yaml:"admin" toml:"admin"`
+	Verbose        bool   `yaml:"verbose" toml:"verbose"`
+	FileParameters `yaml:",inline"`
+
+	ReadHeaderTimeout Duration `yaml:"read-header-timeout" toml:"read-header-timeout"`
+	WriteTimeout      Duration `yaml:"write-timeout" toml:"write-timeout"`
+	IdleTimeout       Duration `yaml:"idle-timeout" toml:"idle-timeout"`
+
+	TLSCert           []string `yaml:"tls-cert" toml:"tls-cert"`
+	TLSKey            []string `yaml:"tls-key" toml:"tls-key"`
+	TLSPKCS12         []string `yaml:"tls-pkcs12" toml:"tls-pkcs12"`
+	TLSPKCS12Password string   `yaml:"tls-pkcs12-password" toml:"tls-pkcs12-password"`
+	TLSLocalCA        bool     `yaml:"tls-local-ca" toml:"tls-local-ca"`
+	TLSLocalCAName    []string `yaml:"tls-local-ca-name" toml:"tls-local-ca-name"`
+	StateDir          string   `yaml:"state-dir" toml:"state-dir"`
+	ClientCA          string   `yaml:"client-ca" toml:"client-ca"`
+	ClientAuth        string   `yaml:"client-auth" toml:"client-auth"`
+	H2C               bool     `yaml:"h2c" toml:"h2c"`
+
+	GracePeriod    Duration `yaml:"grace-period" toml:"grace-period"`
+	ReloadInterval Duration `yaml:"reload-interval" toml:"reload-interval"`
+
+	Routes []FileRoute `yaml:"routes" toml:"routes"`
+}
+
+// FileRoute is a route of the routing table in the configuration file
+type FileRoute struct {
+	Host           string `yaml:"host" toml:"host"`
+	PathPrefix     string `yaml:"path-prefix" toml:"path-prefix"`
+	Destination    string `yaml:"destination" toml:"destination"`
+	FileParameters `yaml:",inline"`
+}
+
+// FileParameters is the parameters for the forwarding in the configuration file
+type FileParameters struct {
+	Rewrite map[string]string `yaml:"rewrite" toml:"rewrite"` // map[oldPath]newPath
+
+	Header        map[string]string `yaml:"header" toml:"header"`
+	Username      string            `yaml:"username" toml:"username"`
+	Password      string            `yaml:"password" toml:"password"`
+	RequestHeader []FileHeaderRule  `yaml:"request-header" toml:"request-header"`
+
+	CACert            string `yaml:"ca-cert" toml:"ca-cert"`
+	CACertOnly        bool   `yaml:"ca-cert-only" toml:"ca-cert-only"`
+	PKCS12            string `yaml:"pkcs12" toml:"pkcs12"`
+	PKCS12Password    string `yaml:"pkcs12-password" toml:"pkcs12-password"`
+	ClientCert        string `yaml:"client-cert" toml:"client-cert"`
+	ClientKey         string `yaml:"client-key" toml:"client-key"`
+	ClientKeyPassword string `yaml:"client-key-password" toml:"client-key-password"`
+
+	MinTLSVersion string   `yaml:"min-tls-version" toml:"min-tls-version"`
+	MaxTLSVersion string   `yaml:"max-tls-version" toml:"max-tls-version"`
+	CipherSuites  []string `yaml:"cipher-suites" toml:"cipher-suites"`
+	Curves        []string `yaml:"curves" toml:"curves"`
+	ServerName    string   `yaml:"server-name" toml:"server-name"`
+	Insecure      bool     `yaml:"insecure" toml:"insecure"`
+
+	Balance             string   `yaml:"balance" toml:"balance"`
+	HealthCheckPath     string   `yaml:"health-check-path" toml:"health-check-path"`
+	HealthCheckInterval Duration `yaml:"health-check-interval" toml:"health-check-interval"`
+	HealthCheckTimeout  Duration `yaml:"health-check-timeout" toml:"health-check-timeout"`
+	MaxFails            int      `yaml:"max-fails" toml:"max-fails"`
+	FailCooldown        Duration `yaml:"fail-cooldown" toml:"fail-cooldown"`
+	DestinationH2C      bool     `yaml:"destination-h2c" toml:"destination-h2c"`
+
+	Retries            int      `yaml:"retries" toml:"retries"`
+	RetryBackoff       Duration `yaml:"retry-backoff" toml:"retry-backoff"`
+	RetryMaxBackoff    Duration `yaml:"retry-max-backoff" toml:"retry-max-backoff"`
+	RetryStatus        []int    `yaml:"retry-status" toml:"retry-status"`
+	RetryNonIdempotent bool     `yaml:"retry-non-idempotent" toml:"retry-non-idempotent"`
+	RetryBodyLimit     int64    `yaml:"retry-body-limit" toml:"retry-body-limit"`
+	RetryBudget        Duration `yaml:"retry-budget" toml:"retry-budget"`
+
+	ErrorFormat string `yaml:"error-format" toml:"error-format"`
+
+	DialTimeout           Duration `yaml:"dial-timeout" toml:"dial-timeout"`
+	TLSHandshakeTimeout   Duration `yaml:"tls-handshake-timeout" toml:"tls-handshake-timeout"`
+	ResponseHeaderTimeout Duration `yaml:"response-header-timeout" toml:"response-header-timeout"`
+	IdleConnTimeout       Duration `yaml:"idle-conn-timeout" toml:"idle-conn-timeout"`
+	RequestTimeout        Duration `yaml:"request-timeout" toml:"request-timeout"`
+
+	ClientCertSubjectHeader string `yaml:"client-cert-subject-header" toml:"client-cert-subject-header"`
+	ClientCertSANHeader     string `yaml:"client-cert-san-header" toml:"client-cert-san-header"`
+	ClientCertHeader        string `yaml:"client-cert-header" toml:"client-cert-header"`
+
+	FlushInterval Duration `yaml:"flush-interval" toml:"flush-interval"`
+
+	XForwarded   string   `yaml:"x-forwarded" toml:"x-forwarded"`
+	Forwarded    string   `yaml:"forwarded" toml:"forwarded"`
+	Via          string   `yaml:"via" toml:"via"`
+	TrustedProxy []string `yaml:"trusted-proxy" toml:"trusted-proxy"`
+
+	RewriteLocation bool `yaml:"rewrite-location" toml:"rewrite-location"`
+	RewriteCookie   bool `yaml:"rewrite-cookie" toml:"rewrite-cookie"`
+
+	ReplaceBody      map[string]string `yaml:"replace-body" toml:"replace-body"` // map[old]new
+	RewriteBody      map[string]string `yaml:"rewrite-body" toml:"rewrite-body"` // map[regexp]replacement
+	RewriteBodyType  []string          `yaml:"rewrite-body-type" toml:"rewrite-body-type"`
+	RewriteBodyLimit int64             `yaml:"rewrite-body-limit" toml:"rewrite-body-limit"`
+
+	ResponseHeader []FileHeaderRule `yaml:"response-header" toml:"response-header"`
+
+	CORS            bool     `yaml:"cors" toml:"cors"`
+	CORSOrigin      []string `yaml:"cors-origin" toml:"cors-origin"`
+	CORSMethod      []string `yaml:"cors-method" toml:"cors-method"`
+	CORSHeader      []string `yaml:"cors-header" toml:"cors-header"`
+	CORSCredentials bool     `yaml:"cors-credentials" toml:"cors-credentials"`
+	CORSMaxAge      Duration `yaml:"cors-max-age" toml:"cors-max-age"`
+	CORSReflect     bool     `yaml:"cors-reflect" toml:"cors-reflect"`
+}
+
+// FileHeaderRule is the HeaderRule in the configuration file
+type FileHeaderRule struct {
+	Action  string   `yaml:"action" toml:"action"`
+	Name    string   `yaml:"name" toml:"name"`
+	Value   string   `yaml:"value" toml:"value"`
+	Pattern string   `yaml:"pattern" toml:"pattern"`
+	Status  []int    `yaml:"status" toml:"status"`
+	Type    []string `yaml:"type" toml:"type"`
+}
+
+// HeaderRule converts to the HeaderRule
+func (r *FileHeaderRule) HeaderRule() HeaderRule {
+	return HeaderRule{
+		Action:       r.Action,
+		Name:         r.Name,
+		Value:        r.Value,
+		Pattern:      r.Pattern,
+		StatusCodes:  r.Status,
+		ContentTypes: r.Type,
+	}
+}
+
+// Duration is time.Duration which is decoded from the string such as "10s" in the configuration file
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Parameters converts to the Parameters. The returned Parameters is not setup yet.
+func (f *FileParameters) Parameters() Parameters {
+	p := Parameters{}
+	p.RewritePaths = f.Rewrite
+
+	p.Header = make(http.Header, len(f.Header))
+	names := make([]string, 0, len(f.Header))
+	for name := range f.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p.Header.Add(strings.TrimSpace(name), strings.TrimSpace(f.Header[name]))
+	}
+	p.Username = f.Username
+	p.Password = f.Password
+	for i := range f.RequestHeader {
+		p.RequestHeaderRules = append(p.RequestHeaderRules, f.RequestHeader[i].HeaderRule())
+	}
+
+	p.CACertPath = f.CACert
+	p.CAOnly = f.CACertOnly
+	p.PKCS12Path = f.PKCS12
+	p.PKCS12Password = f.PKCS12Password
+	p.CertPath = f.ClientCert
+	p.KeyPath = f.ClientKey
+	p.KeyPassword = f.ClientKeyPassword
+	p.MinVersion = f.MinTLSVersion
+	p.MaxVersion = f.MaxTLSVersion
+	p.CipherSuites = f.CipherSuites
+	p.CurvePreferences = f.Curves
+	p.ServerName = f.ServerName
+	p.Insecure = f.Insecure
+
+	p.Balance = f.Balance
+	p.HealthCheckPath = f.HealthCheckPath
+	p.HealthCheckInterval = time.Duration(f.HealthCheckInterval)
+	p.HealthCheckTimeout = time.Duration(f.HealthCheckTimeout)
+	p.MaxFails = f.MaxFails
+	p.FailCooldown = time.Duration(f.FailCooldown)
+	p.H2C = f.DestinationH2C
+
+	p.Retries = f.Retries
+	p.Backoff = time.Duration(f.RetryBackoff)
+	p.MaxBackoff = time.Duration(f.RetryMaxBackoff)
+	p.StatusCodes = f.RetryStatus
+	p.RetryNonIdempotent = f.RetryNonIdempotent
+	p.BodyLimit = f.RetryBodyLimit
+	p.Budget = time.Duration(f.RetryBudget)
+
+	p.ErrorFormat = f.ErrorFormat
+
+	p.DialTimeout = time.Duration(f.DialTimeout)
+	p.TLSHandshakeTimeout = time.Duration(f.TLSHandshakeTimeout)
+	p.ResponseHeaderTimeout = time.Duration(f.ResponseHeaderTimeout)
+	p.IdleConnTimeout = time.Duration(f.IdleConnTimeout)
+	p.RequestTimeout = time.Duration(f.RequestTimeout)
+
+	p.SubjectHeader = f.ClientCertSubjectHeader
+	p.SANHeader = f.ClientCertSANHeader
+	p.CertHeader = f.ClientCertHeader
+
+	p.FlushInterval = time.Duration(f.FlushInterval)
+
+	p.XForwarded = f.XForwarded
+	p.Forwarded = f.Forwarded
+	p.Via = f.Via
+	p.TrustedProxies = f.TrustedProxy
+
+	p.RewriteLocation = f.RewriteLocation
+	p.RewriteCookie = f.RewriteCookie
+
+	p.ReplaceBody = f.ReplaceBody
+	p.RewriteBody = f.RewriteBody
+	p.RewriteBodyTypes = f.RewriteBodyType
+	p.RewriteBodyLimit = f.RewriteBodyLimit
+
+	for i := range f.ResponseHeader {
+		p.ResponseHeaderRules = append(p.ResponseHeaderRules, f.ResponseHeader[i].HeaderRule())
+	}
+
+	p.EnableCORS = f.CORS
+	p.CORSOrigins = f.CORSOrigin
+	p.CORSMethods = f.CORSMethod
+	p.CORSHeaders = f.CORSHeader
+	p.CORSCredentials = f.CORSCredentials
+	p.CORSMaxAge = time.Duration(f.CORSMaxAge)
+	p.CORSReflect = f.CORSReflect
+	return p
+}
+
+// Server converts to the Server. The returned Server is not setup yet.
+func (f *File) Server() Server {
+	return Server{
+		Addr:              f.Listen,
+		AdminAddr:         f.Admin,
+		ReadHeaderTimeout: time.Duration(f.ReadHeaderTimeout),
+		WriteTimeout:      time.Duration(f.WriteTimeout),
+		IdleTimeout:       time.Duration(f.IdleTimeout),
+		TLSServer: TLSServer{
+			CertPaths:      f.TLSCert,
+			KeyPaths:       f.TLSKey,
+			PKCS12Paths:    f.TLSPKCS12,
+			PKCS12Password: f.TLSPKCS12Password,
+			LocalCA:        f.TLSLocalCA,
+			LocalCANames:   f.TLSLocalCAName,
+			StateDir:       f.StateDir,
+			ClientCAPath:   f.ClientCA,
+			ClientAuth:     f.ClientAuth,
+		},
+		H2C:            f.H2C,
+		GracePeriod:    time.Duration(f.GracePeriod),
+		ReloadInterval: time.Duration(f.ReloadInterval),
+	}
+}
+
+// Route converts to the Route. The returned Route is not setup yet.
+func (r *FileRoute) Route() *Route {
+	return &Route{
+		Host:        r.Host,
+		PathPrefix:  r.PathPrefix,
+		Destination: r.Destination,
+		Parameters:  r.FileParameters.Parameters(),
+	}
+}
+
+// LoadFile loads the configuration file.
+// The format is determined by the file extension, .yaml, .yml or .toml.
+func LoadFile(path string) (*File, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to load config file %v : %v", path, err)
+	}
+	f := File{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = decodeYAML(b, &f)
+	case ".toml":
+		err = decodeTOML(b, &f)
+	default:
+		err = fmt.Errorf("config: unsupported config file extension %q. must be .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func decodeYAML(b []byte, f *File) error {
+	err := yaml.UnmarshalStrict(b, f)
+	if err == nil {
+		return nil
+	}
+	errs := errors.NewMultiLine()
+	if te, ok := err.(*yaml.TypeError); ok {
+		for _, e := range te.Errors {
+			errs.Add(fmt.Errorf("config: %v", e))
+		}
+		return errs
+	}
+	errs.Add(fmt.Errorf("config: failed to decode yaml: %v", err))
+	return errs
+}
+
+func decodeTOML(b []byte, f *File) error {
+	errs := errors.NewMultiLine()
+	md, err := toml.Decode(string(b), f)
+	if err != nil {
+		errs.Add(fmt.Errorf("config: failed to decode toml: %v", err))
+		return errs
+	}
+	for _, k := range md.Undecoded() {
+		errs.Add(fmt.Errorf("config: unknown key %q", k.String()))
+	}
+	if errs.Len() > 0 {
+		return errs
+	}
+	return nil
+}
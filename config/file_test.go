@@ -0,0 +1,121 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/errors"
+)
+
+func TestLoadFile(t *testing.T) {
+	want := &File{
+		Destination: "https://example.com/base",
+		Listen:      "127.0.0.1:18080",
+		Verbose:     true,
+		FileParameters: FileParameters{
+			Rewrite:             map[string]string{"^/old/": "/new/"},
+			Header:              map[string]string{"User-Agent": "my agent"},
+			Username:            "user",
+			Password:            "pass",
+			RequestHeader:       []FileHeaderRule{{Action: "delete", Name: "Cookie"}},
+			CACert:              "testdata/cacert.pem",
+			PKCS12:              "testdata/clicert.pfx",
+			PKCS12Password:      "pass",
+			Balance:             "random",
+			HealthCheckPath:     "/health",
+			HealthCheckInterval: Duration(3 * time.Second),
+			MaxFails:            3,
+			FailCooldown:        Duration(time.Minute),
+			Retries:             2,
+			RetryStatus:         []int{502, 503},
+			RetryBudget:         Duration(10 * time.Second),
+			ErrorFormat:         "json",
+			DialTimeout:         Duration(5 * time.Second),
+			RequestTimeout:      Duration(time.Minute),
+			XForwarded:          "append",
+			TrustedProxy:        []string{"10.0.0.0/8"},
+			ReplaceBody:         map[string]string{"https://example.com": "{listener}"},
+			RewriteBodyLimit:    1 << 20,
+			ResponseHeader: []FileHeaderRule{
+				{Action: "delete", Name: "Strict-Transport-Security"},
+				{Action: "set", Name: "Cache-Control", Value: "no-store", Status: []int{404}},
+			},
+			CORS:       true,
+			CORSOrigin: []string{"http://localhost:3000"},
+		},
+		ReadHeaderTimeout: Duration(3 * time.Second),
+		WriteTimeout:      Duration(30 * time.Second),
+		TLSCert:           []string{"testdata/listener-a-cert.pem"},
+		TLSKey:            []string{"testdata/listener-a-key.pem"},
+		GracePeriod:       Duration(20 * time.Second),
+		ReloadInterval:    Duration(10 * time.Second),
+		Routes: []FileRoute{
+			{
+				Host:        "api.localhost",
+				PathPrefix:  "/v1",
+				Destination: "https://api.example.com",
+				FileParameters: FileParameters{
+					Header: map[string]string{"X-Route": "api"},
+				},
+			},
+		},
+	}
+	for _, path := range []string{"testdata/hfwd.yaml", "testdata/hfwd.toml"} {
+		got, err := LoadFile(path)
+		if err != nil {
+			t.Errorf("%v: failed to load: %v", path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %+v, want %+v", path, got, want)
+		}
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	tt := []struct {
+		path    string
+		wantLen int
+	}{
+		{path: "testdata/unknown-keys.yaml", wantLen: 2},
+		{path: "testdata/unknown-keys.toml", wantLen: 3}, // headers, headers.User-Agent, listn
+	}
+	for _, te := range tt {
+		_, err := LoadFile(te.path)
+		if err == nil {
+			t.Errorf("%v: want an error, got nil", te.path)
+			continue
+		}
+		ml, ok := err.(errors.MultiLine)
+		if !ok {
+			t.Errorf("%v: want errors.MultiLine, got %T", te.path, err)
+			continue
+		}
+		if g, w := ml.Len(), te.wantLen; g != w {
+			t.Errorf("%v: ml.Len() got %v, want %v\n%v", te.path, g, w, ml)
+		}
+	}
+
+	if _, err := LoadFile("testdata/cacert.pem"); err == nil {
+		t.Errorf("unsupported extension: want an error, got nil")
+	}
+}
+
+func TestFileParameters_Parameters(t *testing.T) {
+	f := &FileParameters{
+		Header:   map[string]string{"user-agent": " my agent "},
+		Username: "user",
+		PKCS12:   "testdata/clicert.pfx",
+	}
+	p := f.Parameters()
+	if g, w := p.Header.Get("User-Agent"), "my agent"; g != w {
+		t.Errorf("Header[User-Agent] got %v, want %v", g, w)
+	}
+	if g, w := p.Username, "user"; g != w {
+		t.Errorf("Username got %v, want %v", g, w)
+	}
+	if g, w := p.PKCS12Path, "testdata/clicert.pfx"; g != w {
+		t.Errorf("PKCS12Path got %v, want %v", g, w)
+	}
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Modes of the forwarding headers
+const (
+	ForwardedModeAppend  = "append"  // appends the hop to the incoming values from the trusted proxies. the other incoming values are replaced
+	ForwardedModeReplace = "replace" // replaces the incoming values with the hop
+	ForwardedModeStrip   = "strip"   // removes the incoming values
+)
+
+// ForwardedHeaders is configuration parameters for the headers which record the original client and the hop,
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host, RFC 7239 Forwarded and Via.
+type ForwardedHeaders struct {
+	XForwarded     string   // mode of the X-Forwarded-* headers or blank. blank passes the incoming values through
+	Forwarded      string   // mode of the Forwarded header or blank. blank passes the incoming values through
+	Via            string   // mode of the Via header or blank. blank passes the incoming values through
+	TrustedProxies []string // CIDRs or IPs of the trusted proxies whose incoming values are honoured in the append mode
+
+	trustedNets []*net.IPNet
+}
+
+// setup configuration given parameters
+func (f *ForwardedHeaders) setup() error {
+	for _, mode := range []*string{&f.XForwarded, &f.Forwarded, &f.Via} {
+		switch *mode = strings.ToLower(strings.TrimSpace(*mode)); *mode {
+		case "", ForwardedModeAppend, ForwardedModeReplace, ForwardedModeStrip:
+		default:
+			return fmt.Errorf("config: unknown forwarded header mode %q. must be %v, %v or %v",
+				*mode, ForwardedModeAppend, ForwardedModeReplace, ForwardedModeStrip)
+		}
+	}
+	f.trustedNets = nil
+	for _, s := range f.TrustedProxies {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("config: invalid trusted proxy %q. must be CIDR or IP", s)
+			}
+			f.trustedNets = append(f.trustedNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("config: invalid trusted proxy %q. must be CIDR or IP", s)
+		}
+		f.trustedNets = append(f.trustedNets, ipnet)
+	}
+	return nil
+}
+
+// IsTrustedProxy reports whether the ip is one of the TrustedProxies
+func (f *ForwardedHeaders) IsTrustedProxy(ip net.IP) bool {
+	for _, n := range f.trustedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (f *ForwardedHeaders) String() string {
+	b := strings.Builder{}
+	if f == nil {
+		return b.String()
+	}
+	if f.XForwarded != "" {
+		b.WriteString(fmt.Sprintf("XForwarded: %s\n", f.XForwarded))
+	}
+	if f.Forwarded != "" {
+		b.WriteString(fmt.Sprintf("Forwarded: %s\n", f.Forwarded))
+	}
+	if f.Via != "" {
+		b.WriteString(fmt.Sprintf("Via: %s\n", f.Via))
+	}
+	if len(f.TrustedProxies) > 0 {
+		b.WriteString(fmt.Sprintf("TrustedProxies: %s\n", strings.Join(f.TrustedProxies, ", ")))
+	}
+	return b.String()
+}
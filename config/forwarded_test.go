@@ -0,0 +1,48 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestForwardedHeaders(t *testing.T) {
+	tt := []struct {
+		params  ForwardedHeaders
+		wantErr bool
+	}{
+		{params: ForwardedHeaders{}},
+		{params: ForwardedHeaders{XForwarded: "Append", Forwarded: "replace", Via: "strip"}},
+		{params: ForwardedHeaders{Via: "drop"}, wantErr: true},
+		{params: ForwardedHeaders{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}}},
+		{params: ForwardedHeaders{TrustedProxies: []string{"10.0.0.0/33"}}, wantErr: true},
+		{params: ForwardedHeaders{TrustedProxies: []string{"proxy.localhost"}}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.params.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestForwardedHeaders_IsTrustedProxy(t *testing.T) {
+	f := ForwardedHeaders{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}}
+	if err := f.setup(); err != nil {
+		t.Fatal(err)
+	}
+	tt := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.1.2.3", want: true},
+		{ip: "192.0.2.1", want: true},
+		{ip: "192.0.2.2", want: false},
+		{ip: "2001:db8::1", want: true},
+		{ip: "2001:db9::1", want: false},
+	}
+	for _, te := range tt {
+		if g, w := f.IsTrustedProxy(net.ParseIP(te.ip)), te.want; g != w {
+			t.Errorf("%v: got %v, want %v", te.ip, g, w)
+		}
+	}
+}
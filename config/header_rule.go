@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Actions of the HeaderRule
+const (
+	HeaderActionAdd     = "add"     // adds the Value to the header
+	HeaderActionSet     = "set"     // replaces the values of the header with the Value
+	HeaderActionDelete  = "delete"  // removes the header
+	HeaderActionReplace = "replace" // replaces the matches of the Pattern in the values with the Value. the Value can refer the submatches such as $1
+)
+
+// HeaderRule is the rule to rewrite a header
+type HeaderRule struct {
+	Action       string
+	Name         string
+	Value        string
+	Pattern      string   // regexp of the replace action
+	StatusCodes  []int    // applies the rule only to these status codes of the response. empty means any
+	ContentTypes []string // applies the rule only to these media types of the response such as "text/html" or "text/*". empty means any
+
+	rex *regexp.Regexp
+}
+
+// setup the rule
+func (r *HeaderRule) setup() error {
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Name = http.CanonicalHeaderKey(strings.TrimSpace(r.Name))
+	if r.Name == "" {
+		return fmt.Errorf("config: header rule %q requires the header name", r.Action)
+	}
+	switch r.Action {
+	case HeaderActionAdd, HeaderActionSet, HeaderActionDelete:
+	case HeaderActionReplace:
+		rex, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("config: failed to compile the pattern of the header rule %q: %v", r.Pattern, err)
+		}
+		r.rex = rex
+	default:
+		return fmt.Errorf("config: unknown header rule action %q. must be %v, %v, %v or %v",
+			r.Action, HeaderActionAdd, HeaderActionSet, HeaderActionDelete, HeaderActionReplace)
+	}
+	for _, code := range r.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("config: invalid status code %v of the header rule", code)
+		}
+	}
+	for i, t := range r.ContentTypes {
+		r.ContentTypes[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+	return nil
+}
+
+// Match reports whether the rule applies to the response of the statusCode and the contentType
+func (r *HeaderRule) Match(statusCode int, contentType string) bool {
+	if len(r.StatusCodes) > 0 {
+		var found bool
+		for _, code := range r.StatusCodes {
+			if code == statusCode {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return len(r.ContentTypes) == 0 || matchMediaType(r.ContentTypes, contentType)
+}
+
+// Do rewrites the header h by the rule. The expand replaces the placeholders in the Value.
+func (r *HeaderRule) Do(h http.Header, expand func(string) string) {
+	switch r.Action {
+	case HeaderActionAdd:
+		h.Add(r.Name, expand(r.Value))
+	case HeaderActionSet:
+		h.Set(r.Name, expand(r.Value))
+	case HeaderActionDelete:
+		h.Del(r.Name)
+	case HeaderActionReplace:
+		var vv []string
+		repl := expand(r.Value)
+		for _, v := range h[r.Name] {
+			// the values which become empty are removed
+			if v = r.rex.ReplaceAllString(v, repl); v != "" {
+				vv = append(vv, v)
+			}
+		}
+		if len(vv) == 0 {
+			h.Del(r.Name)
+			return
+		}
+		h[r.Name] = vv
+	}
+}
+
+// String returns string representation of this rule
+func (r *HeaderRule) String() string {
+	b := strings.Builder{}
+	b.WriteString(r.Action + " " + r.Name)
+	switch r.Action {
+	case HeaderActionAdd, HeaderActionSet:
+		b.WriteString(": " + r.Value)
+	case HeaderActionReplace:
+		b.WriteString(": " + r.Pattern + "=" + r.Value)
+	}
+	if len(r.StatusCodes) > 0 {
+		b.WriteString(fmt.Sprintf(" (status %v)", r.StatusCodes))
+	}
+	if len(r.ContentTypes) > 0 {
+		b.WriteString(fmt.Sprintf(" (type %v)", r.ContentTypes))
+	}
+	return b.String()
+}
+
+// matchMediaType reports whether the media type of the contentType is one of the types.
+// The type such as "text/*" matches any subtypes.
+func matchMediaType(types []string, contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		if t == mt || strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeaderRule_setup(t *testing.T) {
+	tt := []struct {
+		rule    HeaderRule
+		wantErr bool
+	}{
+		{rule: HeaderRule{Action: "set", Name: "X-Test", Value: "test"}},
+		{rule: HeaderRule{Action: "Delete", Name: "Server", StatusCodes: []int{200}, ContentTypes: []string{"text/*"}}},
+		{rule: HeaderRule{Action: "replace", Name: "Server", Pattern: "^nginx.*$"}},
+		{rule: HeaderRule{Action: "replace", Name: "Server", Pattern: "("}, wantErr: true},
+		{rule: HeaderRule{Action: "unknown", Name: "Server"}, wantErr: true},
+		{rule: HeaderRule{Action: "delete"}, wantErr: true},
+		{rule: HeaderRule{Action: "delete", Name: "Server", StatusCodes: []int{600}}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.rule.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestHeaderRule_Do(t *testing.T) {
+	tt := []struct {
+		rule HeaderRule
+		want http.Header
+	}{
+		{rule: HeaderRule{Action: "add", Name: "x-test", Value: "new"}, want: http.Header{"X-Test": {"old", "new"}, "Server": {"nginx/1.15"}}},
+		{rule: HeaderRule{Action: "set", Name: "X-Test", Value: "new"}, want: http.Header{"X-Test": {"new"}, "Server": {"nginx/1.15"}}},
+		{rule: HeaderRule{Action: "delete", Name: "Server"}, want: http.Header{"X-Test": {"old"}}},
+		{rule: HeaderRule{Action: "replace", Name: "Server", Pattern: `/[\d.]+$`}, want: http.Header{"X-Test": {"old"}, "Server": {"nginx"}}},
+		{rule: HeaderRule{Action: "replace", Name: "Server", Pattern: `^(\w+)/.*$`, Value: "$1"}, want: http.Header{"X-Test": {"old"}, "Server": {"nginx"}}},
+		{rule: HeaderRule{Action: "replace", Name: "Server", Pattern: `.*`}, want: http.Header{"X-Test": {"old"}}},
+	}
+	for i, te := range tt {
+		if err := te.rule.setup(); err != nil {
+			t.Fatalf("%v: failed to setup: %v", i, err)
+		}
+		h := http.Header{"X-Test": {"old"}, "Server": {"nginx/1.15"}}
+		te.rule.Do(h, func(v string) string { return v })
+		if g, w := h, te.want; !reflect.DeepEqual(g, w) {
+			t.Errorf("%v: header got %v, want %v", i, g, w)
+		}
+	}
+}
+
+func TestHeaderRule_Match(t *testing.T) {
+	tt := []struct {
+		rule        HeaderRule
+		statusCode  int
+		contentType string
+		want        bool
+	}{
+		{rule: HeaderRule{}, statusCode: 200, want: true},
+		{rule: HeaderRule{StatusCodes: []int{404, 500}}, statusCode: 404, want: true},
+		{rule: HeaderRule{StatusCodes: []int{404, 500}}, statusCode: 200, want: false},
+		{rule: HeaderRule{ContentTypes: []string{"text/*"}}, statusCode: 200, contentType: "text/html; charset=utf-8", want: true},
+		{rule: HeaderRule{ContentTypes: []string{"text/*"}}, statusCode: 200, contentType: "application/json", want: false},
+		{rule: HeaderRule{StatusCodes: []int{200}, ContentTypes: []string{"application/json"}}, statusCode: 404, contentType: "application/json", want: false},
+	}
+	for i, te := range tt {
+		if g, w := te.rule.Match(te.statusCode, te.contentType), te.want; g != w {
+			t.Errorf("%v: Match got %v, want %v", i, g, w)
+		}
+	}
+}
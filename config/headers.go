@@ -7,21 +7,32 @@ import (
 	"strings"
 )
 
-// Headers is configuration parameters for the http header
+// Headers is configuration parameters for the http header.
+// The values of the Header and the RequestHeaderRules can contain the placeholders such as {client_ip}, which are expanded for each request.
 type Headers struct {
-	Header   http.Header
-	Username string // Username or blank. for basic authN
-	Password string // Password or blank. for basic authN
+	Header             http.Header
+	Username           string       // Username or blank. for basic authN
+	Password           string       // Password or blank. for basic authN
+	RequestHeaderRules []HeaderRule // rules applied in order after the Header. the rules can not have the conditions of the response
 }
 
 // setup configuration given parameters
 func (h *Headers) setup() error {
+	for i := range h.RequestHeaderRules {
+		r := &h.RequestHeaderRules[i]
+		if len(r.StatusCodes) > 0 || len(r.ContentTypes) > 0 {
+			return fmt.Errorf("config: request header rule %q can not have the status or the type", r.Name)
+		}
+		if err := r.setup(); err != nil {
+			return err
+		}
+	}
 	if len(h.Username) > 0 {
 		src := []byte(h.Username + ":" + h.Password)
 		dst := base64.StdEncoding.EncodeToString(src)
-		//if h.Header == nil {
-		//	h.Header = make(http.Header, 0)
-		//}
+		if h.Header == nil {
+			h.Header = make(http.Header, 0)
+		}
 		h.Header.Set("Authorization", "Basic "+string(dst))
 	}
 	return nil
@@ -42,5 +53,8 @@ func (h *Headers) String() string {
 		}
 		b.WriteString(fmt.Sprintf("Header: %s: %s\n", k, v))
 	}
+	for i := range h.RequestHeaderRules {
+		b.WriteString(fmt.Sprintf("RequestHeaderRule: %s\n", &h.RequestHeaderRules[i]))
+	}
 	return b.String()
 }
@@ -52,3 +52,22 @@ Header: Authorization: **********
 		t.Errorf("String() got %v, want %v", g, w)
 	}
 }
+
+func TestHeaders_RequestHeaderRules(t *testing.T) {
+	tt := []struct {
+		rules   []HeaderRule
+		wantErr bool
+	}{
+		{rules: []HeaderRule{{Action: "delete", Name: "Cookie"}, {Action: "set", Name: "X-Request-Id", Value: "{request_id}"}}},
+		{rules: []HeaderRule{{Action: "delete", Name: "Cookie", StatusCodes: []int{200}}}, wantErr: true},
+		{rules: []HeaderRule{{Action: "delete", Name: "Cookie", ContentTypes: []string{"text/html"}}}, wantErr: true},
+		{rules: []HeaderRule{{Action: "unknown", Name: "Cookie"}}, wantErr: true},
+	}
+	for i, te := range tt {
+		h := Headers{RequestHeaderRules: te.rules}
+		err := h.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
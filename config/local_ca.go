@@ -0,0 +1,289 @@
+package config
+
+import (
+	"container/list"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File names of the local CA in the state directory
+const (
+	localCACertFile = "ca-cert.pem"
+	localCAKeyFile  = "ca-key.pem"
+)
+
+// Validity periods of the certificates issued by the LocalCA
+const (
+	localCAValidity   = 10 * 365 * 24 * time.Hour
+	localLeafValidity = 365 * 24 * time.Hour
+)
+
+// localLeafCacheSize is the max count of the leaf certificates cached by the LocalCA
+const localLeafCacheSize = 128
+
+// DefaultStateDir returns the default state directory, $HOME/.hfwd
+func DefaultStateDir() string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		if u, err := user.Current(); err == nil {
+			home = u.HomeDir
+		}
+	}
+	return filepath.Join(home, ".hfwd")
+}
+
+// LocalCA is the certificate authority persisted under the state directory.
+// LocalCA issues the leaf certificates on the fly for the server names which clients request,
+// only if the names are localhost, the IP of the listener or the allowed names.
+type LocalCA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+
+	allowed []string // allowed names. "*.example.test" matches the subdomains
+
+	mu     sync.Mutex
+	leaves map[string]*list.Element // map[serverName]element of the lru
+	lru    *list.List               // *tls.Certificate in the order of the recent use
+}
+
+// LoadLocalCA loads the local CA from the state directory, or creates and persists a new one if not exists.
+// Blank stateDir means DefaultStateDir.
+func LoadLocalCA(stateDir string) (*LocalCA, error) {
+	if stateDir == "" {
+		stateDir = DefaultStateDir()
+	}
+	certPath := filepath.Join(stateDir, localCACertFile)
+	keyPath := filepath.Join(stateDir, localCAKeyFile)
+
+	certPEM, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return createLocalCA(stateDir, certPath, keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to load local ca cert %v : %v", certPath, err)
+	}
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to load local ca key %v : %v", keyPath, err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to create x509 keypair of the local ca: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse local ca cert %v : %v", certPath, err)
+	}
+	key, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("config: unknown private key type %T", pair.PrivateKey)
+	}
+	return newLocalCA(cert, key, certPEM), nil
+}
+
+func createLocalCA(stateDir, certPath, keyPath string) (*LocalCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to generate the local ca key: %v", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"hfwd"}, CommonName: "hfwd local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to create the local ca cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse the local ca cert: %v", err)
+	}
+	certPEM := encodeCertPEMToMemory(cert)
+	keyPEM, err := encodePrivateKeyPEMToMemory(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return nil, fmt.Errorf("config: failed to create state dir %v : %v", stateDir, err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("config: failed to write local ca key %v : %v", keyPath, err)
+	}
+	if err := ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+		return nil, fmt.Errorf("config: failed to write local ca cert %v : %v", certPath, err)
+	}
+	return newLocalCA(cert, key, certPEM), nil
+}
+
+func newLocalCA(cert *x509.Certificate, key crypto.Signer, certPEM []byte) *LocalCA {
+	return &LocalCA{cert: cert, key: key, certPEM: certPEM, leaves: make(map[string]*list.Element), lru: list.New()}
+}
+
+// AllowNames allows the LocalCA to issue the certificates for the names in addition to localhost.
+// The name such as "*.example.test" allows the subdomains. AllowNames must be called before the GetCertificate.
+func (ca *LocalCA) AllowNames(names []string) {
+	ca.allowed = nil
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			ca.allowed = append(ca.allowed, name)
+		}
+	}
+}
+
+// allows reports whether the LocalCA issues the certificate for the server name which the client requests
+func (ca *LocalCA) allows(name string) bool {
+	if name == "localhost" || strings.HasSuffix(name, ".localhost") {
+		return true
+	}
+	if ip := net.ParseIP(name); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, a := range ca.allowed {
+		if a == name || strings.HasPrefix(a, "*.") && strings.HasSuffix(name, a[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// CertPEM returns the certificate of the local CA in PEM
+func (ca *LocalCA) CertPEM() []byte {
+	return ca.certPEM
+}
+
+// GetCertificate returns the leaf certificate for the server name of the client hello.
+// GetCertificate can be used as tls.Config.GetCertificate.
+func (ca *LocalCA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	if name != "" && !ca.allows(name) {
+		return nil, fmt.Errorf("config: the local ca does not issue the certificate for %q", name)
+	}
+	if name == "" && hello.Conn != nil {
+		// clients do not send the SNI when they connect by the IP address
+		if host, _, err := net.SplitHostPort(hello.Conn.LocalAddr().String()); err == nil {
+			name = host
+		}
+	}
+	if name == "" {
+		name = "localhost"
+	}
+
+	if leaf := ca.cachedLeaf(name); leaf != nil {
+		return leaf, nil
+	}
+	// issues outside the lock not to block the other handshakes
+	leaf, err := ca.issue(name)
+	if err != nil {
+		return nil, err
+	}
+	ca.cacheLeaf(name, leaf)
+	return leaf, nil
+}
+
+// cachedLeaf returns the cached leaf certificate for the name, or nil if not cached or expired
+func (ca *LocalCA) cachedLeaf(name string) *tls.Certificate {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	e, ok := ca.leaves[name]
+	if !ok {
+		return nil
+	}
+	leaf := e.Value.(*tls.Certificate)
+	if !time.Now().Before(leaf.Leaf.NotAfter) {
+		ca.lru.Remove(e)
+		delete(ca.leaves, name)
+		return nil
+	}
+	ca.lru.MoveToFront(e)
+	return leaf
+}
+
+// cacheLeaf caches the leaf certificate for the name, and evicts the least recently used ones beyond the localLeafCacheSize
+func (ca *LocalCA) cacheLeaf(name string, leaf *tls.Certificate) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+	if e, ok := ca.leaves[name]; ok {
+		ca.lru.Remove(e)
+	}
+	ca.leaves[name] = ca.lru.PushFront(leaf)
+	for ca.lru.Len() > localLeafCacheSize {
+		e := ca.lru.Back()
+		ca.lru.Remove(e)
+		delete(ca.leaves, e.Value.(*tls.Certificate).Leaf.Subject.CommonName)
+	}
+}
+
+// issue issues a new leaf certificate for the name
+func (ca *LocalCA) issue(name string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to generate the leaf key: %v", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"hfwd"}, CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(localLeafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to issue the leaf cert for %v: %v", name, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to parse the leaf cert for %v: %v", name, err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	n, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("config: failed to generate a serial number: %v", err)
+	}
+	return n, nil
+}
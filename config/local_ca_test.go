@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLocalCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hfwd-local-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateDir := filepath.Join(dir, "state")
+
+	ca, err := LoadLocalCA(stateDir)
+	if err != nil {
+		t.Fatalf("failed to create the local ca: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(stateDir, localCAKeyFile))
+	if err != nil {
+		t.Fatalf("the local ca key is not persisted: %v", err)
+	}
+	if g, w := fi.Mode().Perm(), os.FileMode(0600); g != w {
+		t.Errorf("key file mode got %v, want %v", g, w)
+	}
+
+	loaded, err := LoadLocalCA(stateDir)
+	if err != nil {
+		t.Fatalf("failed to load the local ca: %v", err)
+	}
+	if !bytes.Equal(ca.CertPEM(), loaded.CertPEM()) {
+		t.Errorf("loaded CertPEM got %s, want %s", loaded.CertPEM(), ca.CertPEM())
+	}
+}
+
+func TestTLSServer_LocalCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hfwd-local-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tlss := TLSServer{
+		CertPaths: []string{"testdata/listener-a-cert.pem"},
+		KeyPaths:  []string{"testdata/listener-a-key.pem"},
+		LocalCA:   true,
+		StateDir:  dir,
+	}
+	if err := tlss.setup(); err != nil {
+		t.Fatal(err)
+	}
+	ca, err := LoadLocalCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// httptest.Server adds its own certificate when the tls.Config has no Certificates
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", tlss.TLSServerConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	go http.Serve(ln, http.HandlerFunc(ok))
+
+	tt := []struct {
+		serverName string
+		wantIssuer string
+	}{
+		{serverName: "a.localhost", wantIssuer: "a.localhost"},
+		{serverName: "c.localhost", wantIssuer: "hfwd local CA"},
+		{serverName: "", wantIssuer: "hfwd local CA"}, // 127.0.0.1
+	}
+	for _, te := range tt {
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(ca.CertPEM())
+		a, _ := ioutil.ReadFile("testdata/listener-a-cert.pem")
+		roots.AppendCertsFromPEM(a)
+
+		clientCfg := &tls.Config{ServerName: te.serverName, RootCAs: roots}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		resp, err := client.Get("https://" + ln.Addr().String())
+		if err != nil {
+			t.Errorf("%q: failed GET request: %v", te.serverName, err)
+			continue
+		}
+		resp.Body.Close()
+		if g, w := resp.TLS.PeerCertificates[0].Issuer.CommonName, te.wantIssuer; g != w {
+			t.Errorf("%q: issuer got %v, want %v", te.serverName, g, w)
+		}
+	}
+}
+
+func TestLocalCA_GetCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hfwd-local-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca, err := LoadLocalCA(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.AllowNames([]string{"app.test", "*.api.test"})
+
+	tt := []struct {
+		serverName string
+		wantErr    bool
+	}{
+		{serverName: "localhost"},
+		{serverName: "a.localhost"},
+		{serverName: "App.Test"},
+		{serverName: "v1.api.test"},
+		{serverName: "api.test", wantErr: true},
+		{serverName: "evil.example.com", wantErr: true},
+	}
+	for _, te := range tt {
+		_, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: te.serverName})
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%q: err got %v, want err %v", te.serverName, err, w)
+		}
+	}
+
+	t.Run("cache", func(t *testing.T) {
+		first, _ := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+		if g, _ := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"}); g != first {
+			t.Errorf("the cached certificate is not used")
+		}
+		for i := 0; i < localLeafCacheSize+10; i++ {
+			if _, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: fmt.Sprintf("%d.localhost", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if g, w := len(ca.leaves), localLeafCacheSize; g != w {
+			t.Errorf("len(leaves) got %v, want %v", g, w)
+		}
+		if g, w := ca.lru.Len(), localLeafCacheSize; g != w {
+			t.Errorf("lru.Len() got %v, want %v", g, w)
+		}
+		if _, ok := ca.leaves["localhost"]; ok {
+			t.Errorf("the least recently used certificate is not evicted")
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParsePrivateKeyPEM(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/client-key-pkcs8.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantKey, err := parsePrivateKeyPEM(want, "")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	tt := []struct {
+		path     string
+		password string
+	}{
+		{path: "testdata/client-key-ec.pem"},
+		{path: "testdata/client-key-encrypted.pem", password: "pass"},
+		{path: "testdata/client-key-legacy-encrypted.pem", password: "pass"},
+	}
+	for _, te := range tt {
+		b, err := ioutil.ReadFile(te.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := parsePrivateKeyPEM(b, te.password)
+		if err != nil {
+			t.Errorf("%v: failed to parse: %v", te.path, err)
+			continue
+		}
+		ek, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			t.Errorf("%v: got %T, want *ecdsa.PrivateKey", te.path, key)
+			continue
+		}
+		if ek.D.Cmp(wantKey.(*ecdsa.PrivateKey).D) != 0 {
+			t.Errorf("%v: got a different key", te.path)
+		}
+	}
+
+	b, err := ioutil.ReadFile("testdata/client-rsa-key-pkcs1.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parsePrivateKeyPEM(b, "")
+	if err != nil {
+		t.Fatalf("pkcs1: failed to parse: %v", err)
+	}
+	if _, ok := key.(*rsa.PrivateKey); !ok {
+		t.Errorf("pkcs1: got %T, want *rsa.PrivateKey", key)
+	}
+}
+
+func TestParsePrivateKeyPEM_Errors(t *testing.T) {
+	tt := []struct {
+		path     string
+		password string
+	}{
+		{path: "testdata/client-key-encrypted.pem"},
+		{path: "testdata/client-key-encrypted.pem", password: "wrong"},
+		{path: "testdata/client-key-legacy-encrypted.pem"},
+		{path: "testdata/client-key-legacy-encrypted.pem", password: "wrong"},
+		{path: "testdata/client-chain.pem"},
+	}
+	for _, te := range tt {
+		b, err := ioutil.ReadFile(te.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parsePrivateKeyPEM(b, te.password); err == nil {
+			t.Errorf("%v (password %q): want an error, got nil", te.path, te.password)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultRewriteBodyLimit is the default value of the ResponseBody.RewriteBodyLimit
+const DefaultRewriteBodyLimit = 10 << 20
+
+// DefaultRewriteBodyTypes is the default value of the ResponseBody.RewriteBodyTypes
+var DefaultRewriteBodyTypes = []string{
+	"text/html",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+}
+
+// ListenerPlaceholder in the replacement of the BodyRule is replaced with the URL of the listener such as "http://127.0.0.1:8080"
+const ListenerPlaceholder = "{listener}"
+
+// ResponseBody is configuration parameters for rewriting the response bodies by the substitution rules.
+// The compressed bodies are decoded and encoded again with the same Content-Encoding.
+type ResponseBody struct {
+	ReplaceBody      map[string]string // map[old]new of the literal substitutions
+	RewriteBody      map[string]string // map[regexp]replacement of the regexp substitutions. the replacement can refer the submatches such as $1
+	RewriteBodyTypes []string          // media types of the bodies to rewrite such as "text/html" or "text/*". empty means DefaultRewriteBodyTypes
+	RewriteBodyLimit int64             // max size of the body to rewrite or 0. 0 means DefaultRewriteBodyLimit. larger bodies are streamed unmodified
+	bodyRules        []*BodyRule
+}
+
+// BodyRules returns the substitution rules of the response body.
+// The literal rules come first in the descending order of the length, and the regexp rules follow in the order of the expression.
+func (r *ResponseBody) BodyRules() []*BodyRule {
+	return r.bodyRules
+}
+
+// IsRewriteBodyType reports whether the body of the contentType is rewritten
+func (r *ResponseBody) IsRewriteBodyType(contentType string) bool {
+	return matchMediaType(r.RewriteBodyTypes, contentType)
+}
+
+// setup configuration given parameters
+func (r *ResponseBody) setup() error {
+	if r.RewriteBodyLimit < 0 {
+		return fmt.Errorf("config: rewrite body limit must not be negative")
+	}
+	if r.RewriteBodyLimit == 0 {
+		r.RewriteBodyLimit = DefaultRewriteBodyLimit
+	}
+	types := r.RewriteBodyTypes
+	if len(types) == 0 {
+		types = DefaultRewriteBodyTypes
+	}
+	r.RewriteBodyTypes = make([]string, len(types))
+	for i, t := range types {
+		r.RewriteBodyTypes[i] = strings.ToLower(strings.TrimSpace(t))
+	}
+
+	r.bodyRules = nil
+	olds := make([]string, 0, len(r.ReplaceBody))
+	for old := range r.ReplaceBody {
+		if old == "" {
+			return fmt.Errorf("config: replace body rule must not be empty")
+		}
+		olds = append(olds, old)
+	}
+	sort.Slice(olds, func(i, j int) bool {
+		if len(olds[i]) != len(olds[j]) {
+			return len(olds[i]) > len(olds[j])
+		}
+		return olds[i] < olds[j]
+	})
+	for _, old := range olds {
+		r.bodyRules = append(r.bodyRules, &BodyRule{old: []byte(old), repl: r.ReplaceBody[old]})
+	}
+
+	exprs := make([]string, 0, len(r.RewriteBody))
+	for expr := range r.RewriteBody {
+		exprs = append(exprs, expr)
+	}
+	sort.Strings(exprs)
+	for _, expr := range exprs {
+		rex, err := regexp.Compile(expr)
+		if err != nil {
+			return fmt.Errorf("config: failed to compile the rewrite body rule %q: %v", expr, err)
+		}
+		r.bodyRules = append(r.bodyRules, &BodyRule{rex: rex, repl: r.RewriteBody[expr]})
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (r *ResponseBody) String() string {
+	b := strings.Builder{}
+	if r == nil || len(r.bodyRules) == 0 {
+		return b.String()
+	}
+	for _, rule := range r.bodyRules {
+		b.WriteString(fmt.Sprintf("RewriteBody: %s\n", rule))
+	}
+	b.WriteString(fmt.Sprintf("RewriteBodyTypes: %v\n", r.RewriteBodyTypes))
+	b.WriteString(fmt.Sprintf("RewriteBodyLimit: %d\n", r.RewriteBodyLimit))
+	return b.String()
+}
+
+// BodyRule is the substitution rule of the response body
+type BodyRule struct {
+	old  []byte         // literal to replace, or nil for the regexp rule
+	rex  *regexp.Regexp // regexp to replace, or nil for the literal rule
+	repl string
+}
+
+// Do replaces the matches in the b. The ListenerPlaceholder in the replacement is replaced with the listener.
+func (r *BodyRule) Do(b []byte, listener string) []byte {
+	repl := strings.Replace(r.repl, ListenerPlaceholder, listener, -1)
+	if r.rex != nil {
+		return r.rex.ReplaceAll(b, []byte(repl))
+	}
+	return bytes.Replace(b, r.old, []byte(repl), -1)
+}
+
+// String returns string representation of this rule
+func (r *BodyRule) String() string {
+	if r.rex != nil {
+		return fmt.Sprintf("regexp %s: %s", r.rex, r.repl)
+	}
+	return fmt.Sprintf("%s: %s", r.old, r.repl)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestResponseBody(t *testing.T) {
+	tt := []struct {
+		body    ResponseBody
+		wantErr bool
+	}{
+		{body: ResponseBody{}},
+		{body: ResponseBody{ReplaceBody: map[string]string{"https://example.com": ListenerPlaceholder}, RewriteBodyLimit: 1 << 20}},
+		{body: ResponseBody{RewriteBody: map[string]string{`https://([a-z]+)\.example\.com`: "/$1"}}},
+		{body: ResponseBody{ReplaceBody: map[string]string{"": "new"}}, wantErr: true},
+		{body: ResponseBody{RewriteBody: map[string]string{"(": "new"}}, wantErr: true},
+		{body: ResponseBody{RewriteBodyLimit: -1}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.body.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestResponseBody_BodyRules(t *testing.T) {
+	body := ResponseBody{
+		ReplaceBody: map[string]string{
+			"https://example.com":     ListenerPlaceholder,
+			"https://example.com/api": ListenerPlaceholder + "/v1",
+		},
+		RewriteBody: map[string]string{`"id":\s*(\d+)`: `"id":"$1"`},
+	}
+	if err := body.setup(); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+	b := []byte(`{"id": 1, "self": "https://example.com/api/1", "home": "https://example.com/"}`)
+	for _, rule := range body.BodyRules() {
+		b = rule.Do(b, "http://127.0.0.1:8080")
+	}
+	if g, w := string(b), `{"id":"1", "self": "http://127.0.0.1:8080/v1/1", "home": "http://127.0.0.1:8080/"}`; g != w {
+		t.Errorf("body got %v, want %v", g, w)
+	}
+}
+
+func TestResponseBody_IsRewriteBodyType(t *testing.T) {
+	tt := []struct {
+		types       []string
+		contentType string
+		want        bool
+	}{
+		{contentType: "text/html; charset=utf-8", want: true},
+		{contentType: "application/json", want: true},
+		{contentType: "image/png", want: false},
+		{contentType: "", want: false},
+		{types: []string{"text/*"}, contentType: "text/plain", want: true},
+		{types: []string{"text/*"}, contentType: "application/json", want: false},
+		{types: []string{"Application/XML"}, contentType: "application/xml", want: true},
+	}
+	for i, te := range tt {
+		body := ResponseBody{RewriteBodyTypes: te.types}
+		if err := body.setup(); err != nil {
+			t.Fatalf("%v: failed to setup: %v", i, err)
+		}
+		if g, w := body.IsRewriteBodyType(te.contentType), te.want; g != w {
+			t.Errorf("%v: IsRewriteBodyType(%q) got %v, want %v", i, te.contentType, g, w)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResponseHeaders is configuration parameters for rewriting the response headers from the destination.
+// The rules are applied in order.
+type ResponseHeaders struct {
+	ResponseHeaderRules []HeaderRule
+}
+
+// setup configuration given parameters
+func (r *ResponseHeaders) setup() error {
+	for i := range r.ResponseHeaderRules {
+		if err := r.ResponseHeaderRules[i].setup(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (r *ResponseHeaders) String() string {
+	b := strings.Builder{}
+	if r == nil {
+		return b.String()
+	}
+	for i := range r.ResponseHeaderRules {
+		b.WriteString(fmt.Sprintf("ResponseHeaderRule: %s\n", &r.ResponseHeaderRules[i]))
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default values of the Retry
+const (
+	DefaultRetryBackoff    = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 2 * time.Second
+	DefaultRetryBodyLimit  = 1 << 20
+)
+
+// Retry is configuration parameters for the retry of the forwarding.
+// Requests of the idempotent methods are retried on the transport errors and the StatusCodes.
+// Requests of the other methods are retried only on the StatusCodes and only if the RetryNonIdempotent.
+type Retry struct {
+	Retries            int           // max count of the retries or 0. 0 disables the retry
+	Backoff            time.Duration // base duration of the exponential backoff or 0. 0 means DefaultRetryBackoff
+	MaxBackoff         time.Duration // max duration of the exponential backoff or 0. 0 means DefaultRetryMaxBackoff
+	StatusCodes        []int         // response status codes to retry
+	RetryNonIdempotent bool          // retries the requests of the non-idempotent methods such as POST on the StatusCodes
+	BodyLimit          int64         // max size of the request body buffered for the retry or 0. 0 means DefaultRetryBodyLimit
+	Budget             time.Duration // max duration of a request including the retries or 0. 0 means unlimited
+}
+
+// setup configuration given parameters
+func (r *Retry) setup() error {
+	if r.Retries < 0 || r.Backoff < 0 || r.MaxBackoff < 0 || r.BodyLimit < 0 || r.Budget < 0 {
+		return fmt.Errorf("config: retry parameters must not be negative")
+	}
+	for _, code := range r.StatusCodes {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("config: invalid retry status code %v", code)
+		}
+	}
+	if r.Backoff == 0 {
+		r.Backoff = DefaultRetryBackoff
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = DefaultRetryMaxBackoff
+	}
+	if r.BodyLimit == 0 {
+		r.BodyLimit = DefaultRetryBodyLimit
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (r *Retry) String() string {
+	b := strings.Builder{}
+	if r == nil || r.Retries == 0 {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("Retries: %d (backoff %v, max backoff %v, budget %v)\n", r.Retries, r.Backoff, r.MaxBackoff, r.Budget))
+	b.WriteString(fmt.Sprintf("RetryStatusCodes: %v (non-idempotent %v)\n", r.StatusCodes, r.RetryNonIdempotent))
+	b.WriteString(fmt.Sprintf("RetryBodyLimit: %d\n", r.BodyLimit))
+	return b.String()
+}
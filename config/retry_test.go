@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	tt := []struct {
+		retry   Retry
+		wantErr bool
+	}{
+		{retry: Retry{Retries: 2, StatusCodes: []int{502, 503, 504}}},
+		{retry: Retry{Retries: -1}, wantErr: true},
+		{retry: Retry{Retries: 2, Backoff: -1}, wantErr: true},
+		{retry: Retry{Retries: 2, StatusCodes: []int{1000}}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.retry.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestRetry_Defaults(t *testing.T) {
+	r := Retry{Retries: 2}
+	if err := r.setup(); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+	if g, w := r.Backoff, DefaultRetryBackoff; g != w {
+		t.Errorf("Backoff got %v, want %v", g, w)
+	}
+	if g, w := r.MaxBackoff, DefaultRetryMaxBackoff; g != w {
+		t.Errorf("MaxBackoff got %v, want %v", g, w)
+	}
+	if g, w := r.BodyLimit, int64(DefaultRetryBodyLimit); g != w {
+		t.Errorf("BodyLimit got %v, want %v", g, w)
+	}
+}
+
+func TestRetry_String(t *testing.T) {
+	r := &Retry{
+		Retries:            2,
+		Backoff:            100 * time.Millisecond,
+		MaxBackoff:         time.Second,
+		StatusCodes:        []int{502, 503},
+		RetryNonIdempotent: true,
+		BodyLimit:          1024,
+		Budget:             10 * time.Second,
+	}
+	got := fmt.Sprintf("%v", r)
+	want := `Retries: 2 (backoff 100ms, max backoff 1s, budget 10s)
+RetryStatusCodes: [502 503] (non-idempotent true)
+RetryBodyLimit: 1024
+`
+	if g, w := got, want; g != w {
+		t.Errorf("String() got %v, want %v", g, w)
+	}
+}
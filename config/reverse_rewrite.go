@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReverseRewrite is configuration parameters for rewriting the URLs in the response headers,
+// which point at the destination, back to the listener.
+// The paths are rewritten by the reverse of the RewritePaths.
+type ReverseRewrite struct {
+	RewriteLocation bool // rewrites the Location, Content-Location and Refresh headers
+	RewriteCookie   bool // rewrites the Domain and Path attributes of the Set-Cookie headers
+}
+
+// setup configuration given parameters
+func (r *ReverseRewrite) setup() error {
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (r *ReverseRewrite) String() string {
+	b := strings.Builder{}
+	if r == nil {
+		return b.String()
+	}
+	if r.RewriteLocation {
+		b.WriteString(fmt.Sprintf("RewriteLocation: %v\n", r.RewriteLocation))
+	}
+	if r.RewriteCookie {
+		b.WriteString(fmt.Sprintf("RewriteCookie: %v\n", r.RewriteCookie))
+	}
+	return b.String()
+}
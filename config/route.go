@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/kei2100/h-fwd/errors"
+)
+
+// Route is configuration parameters for a route of the routing table
+type Route struct {
+	Host        string // Host header to match or blank. blank matches any hosts
+	PathPrefix  string // path prefix to match or blank. blank matches any paths
+	Destination string // destination URL of this route. comma separated URLs for the load balancing
+	Parameters
+
+	destinationURLs []*url.URL
+}
+
+// DestinationURLs returns the parsed destination URLs
+func (r *Route) DestinationURLs() []*url.URL {
+	return r.destinationURLs
+}
+
+// Setup configuration given parameters
+func (r *Route) Setup() error {
+	errs := errors.NewMultiLine()
+	if r.Destination == "" {
+		errs.Add(fmt.Errorf("config: route %v: requires the destination URL", r.Name()))
+	}
+	r.destinationURLs = nil
+	for _, d := range strings.Split(r.Destination, ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		u, err := url.Parse(d)
+		if err != nil {
+			errs.Add(fmt.Errorf("config: route %v: failed to parse the destination URL: %v", r.Name(), err))
+			continue
+		}
+		r.destinationURLs = append(r.destinationURLs, u)
+	}
+	if len(r.PathPrefix) > 0 && !strings.HasPrefix(r.PathPrefix, "/") {
+		errs.Add(fmt.Errorf("config: route %v: path prefix must start with '/'", r.Name()))
+	}
+	if err := r.Parameters.Setup(); err != nil {
+		errs.Add(fmt.Errorf("config: route %v:\n%v", r.Name(), err))
+	}
+	if errs.Len() > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Name returns the name of this route in the form of <host><path prefix>
+func (r *Route) Name() string {
+	if r == nil {
+		return ""
+	}
+	name := r.Host + r.PathPrefix
+	if name == "" {
+		return "*"
+	}
+	return name
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (r *Route) String() string {
+	b := strings.Builder{}
+	if r == nil {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("Route: %s\n", r.Name()))
+	b.WriteString(fmt.Sprintf("Destination: %s\n", r.Destination))
+	b.WriteString(r.Parameters.String())
+	return b.String()
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/kei2100/h-fwd/errors"
+)
+
+func TestRoute(t *testing.T) {
+	r := &Route{
+		Host:        "api.localhost",
+		PathPrefix:  "/v1",
+		Destination: "https://api1.example.com/base, https://api2.example.com/base",
+		Parameters:  Parameters{URL: URL{RewritePaths: map[string]string{"^/v1": ""}}},
+	}
+	if err := r.Setup(); err != nil {
+		t.Fatalf("failed to setup route: %v", err)
+	}
+	if g, w := len(r.DestinationURLs()), 2; g != w {
+		t.Fatalf("len(DestinationURLs()) got %v, want %v", g, w)
+	}
+	if g, w := r.DestinationURLs()[1].String(), "https://api2.example.com/base"; g != w {
+		t.Errorf("DestinationURLs()[1] got %v, want %v", g, w)
+	}
+	if g, w := len(r.PathRewriters()), 1; g != w {
+		t.Errorf("len(PathRewriters()) got %v, want %v", g, w)
+	}
+	if g, w := r.Name(), "api.localhost/v1"; g != w {
+		t.Errorf("Name() got %v, want %v", g, w)
+	}
+}
+
+func TestRoute_SetupErrors(t *testing.T) {
+	r := &Route{
+		PathPrefix: "v1",
+		Parameters: Parameters{URL: URL{RewritePaths: map[string]string{"(": ""}}},
+	}
+	err := r.Setup()
+	if err == nil {
+		t.Fatalf("want an error, got nil")
+	}
+	// requires the destination, path prefix must start with '/' and failed to compile regexp
+	if g, w := err.(errors.MultiLine).Len(), 3; g != w {
+		t.Errorf("Len() got %v, want %v\n%v", g, w, err)
+	}
+}
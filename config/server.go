@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kei2100/h-fwd/errors"
+)
+
+// Default values of the Server
+const (
+	DefaultReadHeaderTimeout = 10 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultReloadInterval    = 5 * time.Second
+	DefaultGracePeriod       = 10 * time.Second
+)
+
+// Server is configuration parameters for the listener of the hfwd proxy server
+type Server struct {
+	Addr      string // listen addr:port
+	AdminAddr string // listen addr:port for the admin endpoint or blank. blank disables the admin endpoint
+
+	ReadHeaderTimeout time.Duration // timeout of reading the request headers or 0. 0 means no timeout
+	WriteTimeout      time.Duration // timeout of writing the response or 0. 0 means no timeout
+	IdleTimeout       time.Duration // max duration of the idle keep-alive connection is kept or 0. 0 means no timeout
+
+	TLSServer
+	H2C bool // accepts the cleartext HTTP/2 (h2c) on the listener without the TLS
+
+	GracePeriod    time.Duration // max duration of waiting for the in-flight requests on the shutdown or 0. 0 closes the connections immediately
+	ReloadInterval time.Duration // interval of watching the files for the reload or 0. 0 disables the watching
+}
+
+// Setup configuration given parameters
+func (s *Server) Setup() error {
+	errs := errors.NewMultiLine()
+	if s.ReadHeaderTimeout < 0 || s.WriteTimeout < 0 || s.IdleTimeout < 0 {
+		errs.Add(fmt.Errorf("config: server timeouts must not be negative"))
+	}
+	if s.GracePeriod < 0 || s.ReloadInterval < 0 {
+		errs.Add(fmt.Errorf("config: grace period and reload interval must not be negative"))
+	}
+	errs.AddIfErr(s.TLSServer.setup())
+	if s.H2C && s.TLSServer.Enabled() {
+		errs.Add(fmt.Errorf("config: h2c can not be used with the TLS listener. HTTP/2 is negotiated by the TLS ALPN"))
+	}
+	if errs.Len() > 0 {
+		return errs
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (s *Server) String() string {
+	b := strings.Builder{}
+	if s == nil {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("Addr: %v\n", s.Addr))
+	b.WriteString(fmt.Sprintf("AdminAddr: %v\n", s.AdminAddr))
+	b.WriteString(fmt.Sprintf("ReadHeaderTimeout: %v\n", s.ReadHeaderTimeout))
+	b.WriteString(fmt.Sprintf("WriteTimeout: %v\n", s.WriteTimeout))
+	b.WriteString(fmt.Sprintf("IdleTimeout: %v\n", s.IdleTimeout))
+	b.WriteString(s.TLSServer.String())
+	b.WriteString(fmt.Sprintf("H2C: %v\n", s.H2C))
+	b.WriteString(fmt.Sprintf("GracePeriod: %v\n", s.GracePeriod))
+	b.WriteString(fmt.Sprintf("ReloadInterval: %v\n", s.ReloadInterval))
+	return b.String()
+}
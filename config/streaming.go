@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Streaming is configuration parameters for flushing the response body to the client.
+// The streaming responses, which are text/event-stream or of unknown length, are flushed after each read from the upstream.
+type Streaming struct {
+	FlushInterval time.Duration // interval of flushing the other responses or 0. 0 disables the flushing, negative flushes after each read
+}
+
+// setup configuration given parameters
+func (s *Streaming) setup() error {
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (s *Streaming) String() string {
+	b := strings.Builder{}
+	if s == nil || s.FlushInterval == 0 {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("FlushInterval: %v\n", s.FlushInterval))
+	return b.String()
+}
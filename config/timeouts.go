@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Default values of the Timeouts
+const (
+	DefaultDialTimeout         = 30 * time.Second
+	DefaultTLSHandshakeTimeout = 10 * time.Second
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// Timeouts is configuration parameters for the timeouts of the forwarding
+type Timeouts struct {
+	DialTimeout           time.Duration // timeout of the dial to the upstream or 0. 0 means DefaultDialTimeout
+	TLSHandshakeTimeout   time.Duration // timeout of the TLS handshake with the upstream or 0. 0 means DefaultTLSHandshakeTimeout
+	ResponseHeaderTimeout time.Duration // timeout of waiting for the response headers after the request is written or 0. 0 means no timeout
+	IdleConnTimeout       time.Duration // max duration of the idle connection to the upstream is kept or 0. 0 means DefaultIdleConnTimeout
+	RequestTimeout        time.Duration // deadline of the whole request including the retries and the response body or 0. 0 means no timeout
+}
+
+// setup configuration given parameters
+func (t *Timeouts) setup() error {
+	if t.DialTimeout < 0 || t.TLSHandshakeTimeout < 0 || t.ResponseHeaderTimeout < 0 || t.IdleConnTimeout < 0 || t.RequestTimeout < 0 {
+		return fmt.Errorf("config: timeouts must not be negative")
+	}
+	if t.DialTimeout == 0 {
+		t.DialTimeout = DefaultDialTimeout
+	}
+	if t.TLSHandshakeTimeout == 0 {
+		t.TLSHandshakeTimeout = DefaultTLSHandshakeTimeout
+	}
+	if t.IdleConnTimeout == 0 {
+		t.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (t *Timeouts) String() string {
+	b := strings.Builder{}
+	if t == nil {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("DialTimeout: %v\n", t.DialTimeout))
+	b.WriteString(fmt.Sprintf("TLSHandshakeTimeout: %v\n", t.TLSHandshakeTimeout))
+	b.WriteString(fmt.Sprintf("ResponseHeaderTimeout: %v\n", t.ResponseHeaderTimeout))
+	b.WriteString(fmt.Sprintf("IdleConnTimeout: %v\n", t.IdleConnTimeout))
+	b.WriteString(fmt.Sprintf("RequestTimeout: %v\n", t.RequestTimeout))
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeouts(t *testing.T) {
+	tt := []struct {
+		timeouts Timeouts
+		wantErr  bool
+	}{
+		{timeouts: Timeouts{}},
+		{timeouts: Timeouts{DialTimeout: time.Second, RequestTimeout: time.Minute}},
+		{timeouts: Timeouts{DialTimeout: -1}, wantErr: true},
+		{timeouts: Timeouts{RequestTimeout: -1}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.timeouts.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestTimeouts_Defaults(t *testing.T) {
+	to := Timeouts{}
+	if err := to.setup(); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+	if g, w := to.DialTimeout, DefaultDialTimeout; g != w {
+		t.Errorf("DialTimeout got %v, want %v", g, w)
+	}
+	if g, w := to.TLSHandshakeTimeout, DefaultTLSHandshakeTimeout; g != w {
+		t.Errorf("TLSHandshakeTimeout got %v, want %v", g, w)
+	}
+	if g, w := to.IdleConnTimeout, DefaultIdleConnTimeout; g != w {
+		t.Errorf("IdleConnTimeout got %v, want %v", g, w)
+	}
+	if g, w := to.ResponseHeaderTimeout, time.Duration(0); g != w {
+		t.Errorf("ResponseHeaderTimeout got %v, want %v", g, w)
+	}
+	if g, w := to.RequestTimeout, time.Duration(0); g != w {
+		t.Errorf("RequestTimeout got %v, want %v", g, w)
+	}
+}
+
+func TestServer(t *testing.T) {
+	tt := []struct {
+		server  Server
+		wantErr bool
+	}{
+		{server: Server{}},
+		{server: Server{ReadHeaderTimeout: DefaultReadHeaderTimeout, IdleTimeout: DefaultIdleTimeout}},
+		{server: Server{WriteTimeout: -1}, wantErr: true},
+		{server: Server{GracePeriod: DefaultGracePeriod, ReloadInterval: DefaultReloadInterval}},
+		{server: Server{GracePeriod: -1}, wantErr: true},
+		{server: Server{H2C: true}},
+		{server: Server{H2C: true, TLSServer: TLSServer{CertPaths: []string{"testdata/listener-a-cert.pem"}, KeyPaths: []string{"testdata/listener-a-key.pem"}}}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.server.Setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/tls"
@@ -17,13 +19,76 @@ import (
 	"golang.org/x/crypto/pkcs12"
 )
 
+// TLS versions
+const (
+	TLSVersion10 = "1.0"
+	TLSVersion11 = "1.1"
+	TLSVersion12 = "1.2"
+	TLSVersion13 = "1.3"
+)
+
+// versionTLS13 is tls.VersionTLS13, which is available since go1.12.
+// older go uses the max version it supports.
+const versionTLS13 = 0x0304
+
+var tlsVersions = map[string]uint16{
+	TLSVersion10: tls.VersionTLS10,
+	TLSVersion11: tls.VersionTLS11,
+	TLSVersion12: tls.VersionTLS12,
+	TLSVersion13: versionTLS13,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_RC4_128_SHA":                tls.TLS_RSA_WITH_RC4_128_SHA,
+	"TLS_RSA_WITH_3DES_EDE_CBC_SHA":           tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_RC4_128_SHA":        tls.TLS_ECDHE_ECDSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_RC4_128_SHA":          tls.TLS_ECDHE_RSA_WITH_RC4_128_SHA,
+	"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA":     tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+	"X25519": tls.X25519,
+}
+
 // TLSClient is configuration parameters for the tls client certification
 type TLSClient struct {
 	CACertPath string
+	CAOnly     bool // uses the CA certificate as the only root CA instead of appending it to the system cert pool
 
 	PKCS12Path     string
 	PKCS12Password string
 
+	CertPath    string // path of the client certificate PEM. the PEM can contain the intermediate CA certificates following the client certificate
+	KeyPath     string // path of the private key PEM of the client certificate in PKCS8, PKCS1 or EC
+	KeyPassword string // password for the encrypted private key or blank
+
+	MinVersion       string   // min TLS version or blank. blank means the default of the crypto/tls
+	MaxVersion       string   // max TLS version or blank. blank means the default of the crypto/tls
+	CipherSuites     []string // names of the cipher suites such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or empty. empty means the default of the crypto/tls
+	CurvePreferences []string // names of the curves (P256, P384, P521 or X25519) or empty. empty means the default of the crypto/tls
+	ServerName       string   // server name for the SNI and the verification of the certificate or blank. blank means the host of the destination
+	Insecure         bool     // skips the verification of the certificate. DO NOT use this in production
+
 	caCertPEM []byte
 	certPEM   []byte
 	keyPEM    []byte
@@ -40,9 +105,43 @@ func (t *TLSClient) String() string {
 	b.WriteString(fmt.Sprintf("CACertPath: %s\n", t.CACertPath))
 	b.WriteString(fmt.Sprintf("PKCS12Path: %s\n", t.PKCS12Path))
 	b.WriteString(fmt.Sprintf("PKCS12Password: %s\n", strings.Repeat("*", len(t.PKCS12Password))))
+	if t.CertPath != "" {
+		b.WriteString(fmt.Sprintf("CertPath: %s\n", t.CertPath))
+		b.WriteString(fmt.Sprintf("KeyPath: %s\n", t.KeyPath))
+		b.WriteString(fmt.Sprintf("KeyPassword: %s\n", strings.Repeat("*", len(t.KeyPassword))))
+	}
+	if t.CAOnly {
+		b.WriteString("CAOnly: true\n")
+	}
+	if t.MinVersion != "" || t.MaxVersion != "" {
+		b.WriteString(fmt.Sprintf("TLSVersion: %s - %s\n", t.MinVersion, t.MaxVersion))
+	}
+	if len(t.CipherSuites) > 0 {
+		b.WriteString(fmt.Sprintf("CipherSuites: %v\n", t.CipherSuites))
+	}
+	if len(t.CurvePreferences) > 0 {
+		b.WriteString(fmt.Sprintf("CurvePreferences: %v\n", t.CurvePreferences))
+	}
+	if t.ServerName != "" {
+		b.WriteString(fmt.Sprintf("ServerName: %s\n", t.ServerName))
+	}
+	if t.Insecure {
+		b.WriteString("Insecure: true\n")
+	}
 	return b.String()
 }
 
+// FilePaths returns the paths of the files which this configuration loads
+func (t *TLSClient) FilePaths() []string {
+	var paths []string
+	for _, p := range []string{t.CACertPath, t.PKCS12Path, t.CertPath, t.KeyPath} {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
 // TLSClientConfig returns *tls.Config for the tls client certification
 func (t *TLSClient) TLSClientConfig() *tls.Config {
 	return t.tlsConfig
@@ -53,7 +152,9 @@ func (t *TLSClient) setup() error {
 	d := new(errors.DoOrSkip)
 	d.DoOrSkip(t.loadCACert)
 	d.DoOrSkip(t.loadPKCS12)
+	d.DoOrSkip(t.loadKeyPair)
 	d.DoOrSkip(t.loadTLSConfig)
+	d.DoOrSkip(t.tuneTLSConfig)
 	return d.Err()
 }
 
@@ -75,20 +176,44 @@ func (t *TLSClient) loadPKCS12() error {
 	if t.PKCS12Path == "" {
 		return nil
 	}
-	b, err := ioutil.ReadFile(t.PKCS12Path)
+	certPEM, keyPEM, err := loadPKCS12File(t.PKCS12Path, t.PKCS12Password)
+	if err != nil {
+		return err
+	}
+	t.certPEM = certPEM
+	t.keyPEM = keyPEM
+	return nil
+}
+
+// loadKeyPair normally called from the setup() method
+func (t *TLSClient) loadKeyPair() error {
+	if t.CertPath == "" && t.KeyPath == "" {
+		return nil
+	}
+	if t.CertPath == "" || t.KeyPath == "" {
+		return fmt.Errorf("config: client cert and client key must be given together")
+	}
+	if t.PKCS12Path != "" {
+		return fmt.Errorf("config: client cert and pkcs12 can not be given together")
+	}
+	certPEM, err := ioutil.ReadFile(t.CertPath)
+	if err != nil {
+		return fmt.Errorf("config: failed to load client cert file %v : %v", t.CertPath, err)
+	}
+	b, err := ioutil.ReadFile(t.KeyPath)
 	if err != nil {
-		return fmt.Errorf("config: failed to load pkcs12 file %v : %v", t.PKCS12Path, err)
+		return fmt.Errorf("config: failed to load client key file %v : %v", t.KeyPath, err)
 	}
-	key, cert, err := pkcs12.Decode(b, t.PKCS12Password)
+	key, err := parsePrivateKeyPEM(b, t.KeyPassword)
 	if err != nil {
-		return fmt.Errorf("config: failed to decode pkcs12 data: %v", err)
+		return fmt.Errorf("%v: %v", err, t.KeyPath)
 	}
-	kp, err := encodePrivateKeyPEMToMemory(key)
+	keyPEM, err := encodePrivateKeyPEMToMemory(key)
 	if err != nil {
 		return err
 	}
-	t.keyPEM = kp
-	t.certPEM = encodeCertPEMToMemory(cert)
+	t.certPEM = certPEM
+	t.keyPEM = keyPEM
 	return nil
 }
 
@@ -104,10 +229,14 @@ func (t *TLSClient) loadTLSConfig() error {
 		cfg.Certificates = []tls.Certificate{cert}
 	}
 	if certPEM := t.caCertPEM; certPEM != nil {
-		p, err := x509.SystemCertPool()
-		if err != nil {
-			log.Printf("config: system cert pool is not available. creates a new cert pool: %v", err)
-			p = x509.NewCertPool()
+		p := x509.NewCertPool()
+		if !t.CAOnly {
+			sp, err := x509.SystemCertPool()
+			if err != nil {
+				log.Printf("config: system cert pool is not available. creates a new cert pool: %v", err)
+			} else {
+				p = sp
+			}
 		}
 		if ok := p.AppendCertsFromPEM(certPEM); !ok {
 			return fmt.Errorf("config: failed to append ca cert file %v (%v bytes)", t.CACertPath, len(certPEM))
@@ -119,6 +248,107 @@ func (t *TLSClient) loadTLSConfig() error {
 	return nil
 }
 
+// tuneTLSConfig normally called from the setup() method
+func (t *TLSClient) tuneTLSConfig() error {
+	cfg := t.tlsConfig
+	if t.CAOnly && t.CACertPath == "" {
+		return fmt.Errorf("config: ca only requires the ca cert")
+	}
+	var ok bool
+	if t.MinVersion != "" {
+		if cfg.MinVersion, ok = tlsVersions[t.MinVersion]; !ok {
+			return fmt.Errorf("config: unknown tls version %q. must be %v, %v, %v or %v", t.MinVersion, TLSVersion10, TLSVersion11, TLSVersion12, TLSVersion13)
+		}
+	}
+	if t.MaxVersion != "" {
+		if cfg.MaxVersion, ok = tlsVersions[t.MaxVersion]; !ok {
+			return fmt.Errorf("config: unknown tls version %q. must be %v, %v, %v or %v", t.MaxVersion, TLSVersion10, TLSVersion11, TLSVersion12, TLSVersion13)
+		}
+	}
+	if cfg.MinVersion != 0 && cfg.MaxVersion != 0 && cfg.MinVersion > cfg.MaxVersion {
+		return fmt.Errorf("config: min tls version %v is greater than max tls version %v", t.MinVersion, t.MaxVersion)
+	}
+	for _, name := range t.CipherSuites {
+		id, ok := tlsCipherSuites[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("config: unknown cipher suite %q", name)
+		}
+		cfg.CipherSuites = append(cfg.CipherSuites, id)
+	}
+	for _, name := range t.CurvePreferences {
+		id, ok := tlsCurves[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("config: unknown curve %q. must be P256, P384, P521 or X25519", name)
+		}
+		cfg.CurvePreferences = append(cfg.CurvePreferences, id)
+	}
+	cfg.ServerName = t.ServerName
+	// the warning of the insecure is logged by the caller, so as not to repeat it on every reload
+	cfg.InsecureSkipVerify = t.Insecure
+	return nil
+}
+
+// loadPKCS12File loads the PKCS12 file and returns the certificate chain and the private key in PEM.
+// The certificate chain starts with the certificate of the private key, and follows the intermediate CA certificates.
+func loadPKCS12File(path, password string) (certPEM, keyPEM []byte, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: failed to load pkcs12 file %v : %v", path, err)
+	}
+	blocks, err := pkcs12.ToPEM(b, password)
+	if err != nil {
+		return nil, nil, fmt.Errorf("config: failed to decode pkcs12 data: %v", err)
+	}
+	var key crypto.PrivateKey
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("config: failed to parse the certificate in pkcs12 data: %v", err)
+			}
+			certs = append(certs, cert)
+		case pemTypePrivateKey:
+			if key, err = parsePrivateKeyDER(block.Bytes); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if key == nil || len(certs) == 0 {
+		return nil, nil, fmt.Errorf("config: pkcs12 data must contain a private key and a certificate")
+	}
+	keyPEM, err = encodePrivateKeyPEMToMemory(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cert := range leafFirst(certs, key) {
+		certPEM = append(certPEM, encodeCertPEMToMemory(cert)...)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// leafFirst sorts the certs so that the certificate of the private key comes first
+func leafFirst(certs []*x509.Certificate, key crypto.PrivateKey) []*x509.Certificate {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return certs
+	}
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return certs
+	}
+	sorted := make([]*x509.Certificate, 0, len(certs))
+	for _, cert := range certs {
+		if b, err := x509.MarshalPKIXPublicKey(cert.PublicKey); err == nil && bytes.Equal(b, pub) {
+			sorted = append([]*x509.Certificate{cert}, sorted...)
+		} else {
+			sorted = append(sorted, cert)
+		}
+	}
+	return sorted
+}
+
 func encodePrivateKeyPEMToMemory(key interface{}) ([]byte, error) {
 	switch k := key.(type) {
 	case *rsa.PrivateKey:
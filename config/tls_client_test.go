@@ -2,9 +2,12 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 )
 
@@ -13,13 +16,13 @@ func TestTLSClient(t *testing.T) {
 		CACertPath:     "testdata/cacert.pem",
 		PKCS12Path:     "testdata/clicert.pfx",
 		PKCS12Password: "pass",
+		Insecure:       true,
 	}
 	if err := tlsp.setup(); err != nil {
 		t.Fatal(err)
 	}
 
 	clientCfg := tlsp.TLSClientConfig()
-	clientCfg.InsecureSkipVerify = true
 
 	servCert, err := tls.LoadX509KeyPair("testdata/servcert.pem", "testdata/servkey-nopass.pem")
 	if err != nil {
@@ -63,3 +66,155 @@ PKCS12Password: ****
 		t.Errorf("Strings() got %v, want %v", g, w)
 	}
 }
+
+func TestTLSClient_Tuning(t *testing.T) {
+	tlsp := TLSClient{
+		MinVersion:       TLSVersion11,
+		MaxVersion:       TLSVersion12,
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		CurvePreferences: []string{"X25519", "P256"},
+		ServerName:       "a.localhost",
+	}
+	if err := tlsp.setup(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := tlsp.TLSClientConfig()
+	if g, w := cfg.MinVersion, uint16(tls.VersionTLS11); g != w {
+		t.Errorf("MinVersion got %x, want %x", g, w)
+	}
+	if g, w := cfg.MaxVersion, uint16(tls.VersionTLS12); g != w {
+		t.Errorf("MaxVersion got %x, want %x", g, w)
+	}
+	if g, w := cfg.CipherSuites, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}; !reflect.DeepEqual(g, w) {
+		t.Errorf("CipherSuites got %v, want %v", g, w)
+	}
+	if g, w := cfg.CurvePreferences, []tls.CurveID{tls.X25519, tls.CurveP256}; !reflect.DeepEqual(g, w) {
+		t.Errorf("CurvePreferences got %v, want %v", g, w)
+	}
+	if g, w := cfg.ServerName, "a.localhost"; g != w {
+		t.Errorf("ServerName got %v, want %v", g, w)
+	}
+	if cfg.InsecureSkipVerify {
+		t.Errorf("InsecureSkipVerify got true, want false")
+	}
+}
+
+func TestTLSClient_TuningErrors(t *testing.T) {
+	tt := []TLSClient{
+		{MinVersion: "1.4"},
+		{MaxVersion: "tls1.2"},
+		{MinVersion: TLSVersion12, MaxVersion: TLSVersion10},
+		{CipherSuites: []string{"TLS_UNKNOWN"}},
+		{CurvePreferences: []string{"P224"}},
+		{CAOnly: true},
+	}
+	for i, te := range tt {
+		if err := te.setup(); err == nil {
+			t.Errorf("%v: want an error, got nil", i)
+		}
+	}
+}
+
+func TestTLSClient_ServerNameAndCAOnly(t *testing.T) {
+	servCert, err := tls.LoadX509KeyPair("testdata/listener-a-cert.pem", "testdata/listener-a-key.pem")
+	if err != nil {
+		t.Fatalf("failed to load servcert: %v", err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	serv := httptest.NewUnstartedServer(http.HandlerFunc(ok))
+	serv.TLS = &tls.Config{Certificates: []tls.Certificate{servCert}}
+	serv.StartTLS()
+	defer serv.Close()
+
+	tt := []struct {
+		name    string
+		tlsp    TLSClient
+		wantErr bool
+	}{
+		{name: "server name", tlsp: TLSClient{CACertPath: "testdata/listener-a-cert.pem", CAOnly: true, ServerName: "a.localhost"}},
+		{name: "no server name", tlsp: TLSClient{CACertPath: "testdata/listener-a-cert.pem", CAOnly: true}, wantErr: true},
+		{name: "other ca only", tlsp: TLSClient{CACertPath: "testdata/listener-b-cert.pem", CAOnly: true, ServerName: "a.localhost"}, wantErr: true},
+	}
+	for _, te := range tt {
+		if err := te.tlsp.setup(); err != nil {
+			t.Fatalf("%v: %v", te.name, err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: te.tlsp.TLSClientConfig()}}
+		resp, err := client.Get(serv.URL)
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", te.name, err, w)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func TestTLSClient_KeyPair(t *testing.T) {
+	tt := []struct {
+		name string
+		tlsp TLSClient
+	}{
+		{name: "pem", tlsp: TLSClient{CertPath: "testdata/client-chain.pem", KeyPath: "testdata/client-key-encrypted.pem", KeyPassword: "pass"}},
+		{name: "pkcs12", tlsp: TLSClient{PKCS12Path: "testdata/client-chain.pfx", PKCS12Password: "pass"}},
+	}
+	for _, te := range tt {
+		if err := te.tlsp.setup(); err != nil {
+			t.Errorf("%v: failed to setup: %v", te.name, err)
+			continue
+		}
+		certs := te.tlsp.TLSClientConfig().Certificates
+		if g, w := len(certs), 1; g != w {
+			t.Errorf("%v: len(Certificates) got %v, want %v", te.name, g, w)
+			continue
+		}
+		// the client certificate and the intermediate CA certificate
+		if g, w := len(certs[0].Certificate), 2; g != w {
+			t.Errorf("%v: len(Certificate chain) got %v, want %v", te.name, g, w)
+		}
+	}
+}
+
+func TestTLSClient_KeyPairWithServer(t *testing.T) {
+	tlsp := TLSClient{CertPath: "testdata/client-chain.pem", KeyPath: "testdata/client-key-ec.pem", Insecure: true}
+	if err := tlsp.setup(); err != nil {
+		t.Fatal(err)
+	}
+	servCert, err := tls.LoadX509KeyPair("testdata/servcert.pem", "testdata/servkey-nopass.pem")
+	if err != nil {
+		t.Fatalf("failed to load servcert: %v", err)
+	}
+	// the server trusts only the root CA, so the intermediate CA certificate must be sent by the client
+	roots, err := ioutil.ReadFile("testdata/client-root-ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(roots)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	serv := httptest.NewUnstartedServer(http.HandlerFunc(ok))
+	serv.TLS = &tls.Config{Certificates: []tls.Certificate{servCert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	serv.StartTLS()
+	defer serv.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsp.TLSClientConfig()}}
+	resp, err := client.Get(serv.URL)
+	if err != nil {
+		t.Fatalf("failed GET request: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTLSClient_KeyPairErrors(t *testing.T) {
+	tt := []TLSClient{
+		{CertPath: "testdata/client-chain.pem"},
+		{CertPath: "testdata/client-chain.pem", KeyPath: "testdata/client-key-encrypted.pem"},
+		{CertPath: "testdata/client-chain.pem", KeyPath: "testdata/client-rsa-key-pkcs1.pem"},
+		{CertPath: "testdata/client-chain.pem", KeyPath: "testdata/client-key-ec.pem", PKCS12Path: "testdata/clicert.pfx", PKCS12Password: "pass"},
+	}
+	for i, te := range tt {
+		if err := te.setup(); err == nil {
+			t.Errorf("%v: want an error, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Client authentication modes of the listener
+const (
+	ClientAuthRequire       = "require"
+	ClientAuthVerifyIfGiven = "verify-if-given"
+)
+
+// TLSServer is configuration parameters for the tls termination on the listener.
+// The certificate is selected by the SNI of the client hello when several certificates are given.
+type TLSServer struct {
+	CertPaths []string // paths of the certificate PEM. each certificate pairs with the key of the same index
+	KeyPaths  []string // paths of the private key PEM
+
+	PKCS12Paths    []string
+	PKCS12Password string
+
+	LocalCA      bool     // issues the certificates by the local CA for the server names which do not match to the given certificates
+	LocalCANames []string // names which the local CA issues the certificates for in addition to localhost. "*.example.test" allows the subdomains
+	StateDir     string   // directory where the local CA is persisted or blank. blank means DefaultStateDir()
+
+	ClientCAPath string // path of the CA certificate PEM to verify the client certificates or blank. blank disables the client authentication
+	ClientAuth   string // client authentication mode or blank. blank means require
+
+	tlsConfig *tls.Config
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (t *TLSServer) String() string {
+	b := strings.Builder{}
+	if t == nil || !t.Enabled() {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("TLSCertPaths: %v\n", t.CertPaths))
+	b.WriteString(fmt.Sprintf("TLSKeyPaths: %v\n", t.KeyPaths))
+	b.WriteString(fmt.Sprintf("TLSPKCS12Paths: %v\n", t.PKCS12Paths))
+	b.WriteString(fmt.Sprintf("TLSPKCS12Password: %s\n", strings.Repeat("*", len(t.PKCS12Password))))
+	if t.LocalCA {
+		b.WriteString(fmt.Sprintf("TLSLocalCA: %s %v\n", t.StateDir, t.LocalCANames))
+	}
+	if t.ClientCAPath != "" {
+		b.WriteString(fmt.Sprintf("ClientCAPath: %s (%s)\n", t.ClientCAPath, t.ClientAuth))
+	}
+	return b.String()
+}
+
+// Enabled reports whether the listener serves HTTPS
+func (t *TLSServer) Enabled() bool {
+	return len(t.CertPaths) > 0 || len(t.PKCS12Paths) > 0 || t.LocalCA
+}
+
+// FilePaths returns the paths of the files which this configuration loads
+func (t *TLSServer) FilePaths() []string {
+	var paths []string
+	paths = append(paths, t.CertPaths...)
+	paths = append(paths, t.KeyPaths...)
+	paths = append(paths, t.PKCS12Paths...)
+	if t.ClientCAPath != "" {
+		paths = append(paths, t.ClientCAPath)
+	}
+	return paths
+}
+
+// TLSServerConfig returns *tls.Config for the listener, or nil if the listener serves plain HTTP
+func (t *TLSServer) TLSServerConfig() *tls.Config {
+	return t.tlsConfig
+}
+
+// setup configuration given parameters
+func (t *TLSServer) setup() error {
+	if !t.Enabled() {
+		if t.ClientCAPath != "" || t.ClientAuth != "" {
+			return fmt.Errorf("config: client authentication requires the tls on the listener")
+		}
+		return nil
+	}
+	if len(t.CertPaths) != len(t.KeyPaths) {
+		return fmt.Errorf("config: tls cert and tls key must be given in pairs, got %v certs and %v keys", len(t.CertPaths), len(t.KeyPaths))
+	}
+	cfg := tls.Config{}
+	for i := range t.CertPaths {
+		cert, err := tls.LoadX509KeyPair(t.CertPaths[i], t.KeyPaths[i])
+		if err != nil {
+			return fmt.Errorf("config: failed to load tls cert %v and key %v : %v", t.CertPaths[i], t.KeyPaths[i], err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	for _, path := range t.PKCS12Paths {
+		certPEM, keyPEM, err := loadPKCS12File(path, t.PKCS12Password)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("config: failed to create x509 keypair from %v: %v", path, err)
+		}
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+	// the first certificate is used when the SNI does not match to any certificates
+	cfg.BuildNameToCertificate()
+	if t.LocalCA {
+		ca, err := LoadLocalCA(t.StateDir)
+		if err != nil {
+			return err
+		}
+		ca.AllowNames(t.LocalCANames)
+		// tls.Config does not call the GetCertificate for the client hello without the SNI if the Certificates exist
+		names := cfg.NameToCertificate
+		cfg.Certificates, cfg.NameToCertificate = nil, nil
+		cfg.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := lookupNameToCertificate(names, hello.ServerName); cert != nil {
+				return cert, nil
+			}
+			return ca.GetCertificate(hello)
+		}
+	}
+	if err := t.setupClientAuth(&cfg); err != nil {
+		return err
+	}
+	t.tlsConfig = &cfg
+	return nil
+}
+
+// setupClientAuth normally called from the setup() method
+func (t *TLSServer) setupClientAuth(cfg *tls.Config) error {
+	if t.ClientCAPath == "" {
+		if t.ClientAuth != "" {
+			return fmt.Errorf("config: client auth %q requires the client ca", t.ClientAuth)
+		}
+		return nil
+	}
+	switch t.ClientAuth {
+	case "", ClientAuthRequire:
+		t.ClientAuth = ClientAuthRequire
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthVerifyIfGiven:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return fmt.Errorf("config: unknown client auth %q. must be %v or %v", t.ClientAuth, ClientAuthRequire, ClientAuthVerifyIfGiven)
+	}
+	b, err := ioutil.ReadFile(t.ClientCAPath)
+	if err != nil {
+		return fmt.Errorf("config: failed to load client ca file %v : %v", t.ClientCAPath, err)
+	}
+	p := x509.NewCertPool()
+	if ok := p.AppendCertsFromPEM(b); !ok {
+		return fmt.Errorf("config: failed to append client ca file %v (%v bytes)", t.ClientCAPath, len(b))
+	}
+	cfg.ClientCAs = p
+	return nil
+}
+
+// lookupNameToCertificate returns the certificate which matches to the server name including the wildcard, or nil
+func lookupNameToCertificate(names map[string]*tls.Certificate, serverName string) *tls.Certificate {
+	name := strings.TrimRight(strings.ToLower(serverName), ".")
+	if name == "" {
+		return nil
+	}
+	if cert, ok := names[name]; ok {
+		return cert
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		return names["*"+name[i:]]
+	}
+	return nil
+}
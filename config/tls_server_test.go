@@ -0,0 +1,153 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTLSServer(t *testing.T) {
+	tlss := TLSServer{
+		CertPaths: []string{"testdata/listener-a-cert.pem", "testdata/listener-b-cert.pem"},
+		KeyPaths:  []string{"testdata/listener-a-key.pem", "testdata/listener-b-key.pem"},
+	}
+	if err := tlss.setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	serv := httptest.NewUnstartedServer(http.HandlerFunc(ok))
+	serv.TLS = tlss.TLSServerConfig()
+	serv.StartTLS()
+	defer serv.Close()
+
+	tt := []struct {
+		serverName string
+		wantCert   string
+	}{
+		{serverName: "a.localhost", wantCert: "testdata/listener-a-cert.pem"},
+		{serverName: "b.localhost", wantCert: "testdata/listener-b-cert.pem"},
+	}
+	for _, te := range tt {
+		pem, err := ioutil.ReadFile(te.wantCert)
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", te.wantCert, err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(pem)
+
+		clientCfg := &tls.Config{ServerName: te.serverName, RootCAs: roots}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+		resp, err := client.Get(serv.URL)
+		if err != nil {
+			t.Errorf("%v: failed GET request: %v", te.serverName, err)
+			continue
+		}
+		resp.Body.Close()
+		if g, w := resp.StatusCode, 200; g != w {
+			t.Errorf("%v: resp.StatusCode got %v, want %v", te.serverName, g, w)
+		}
+	}
+}
+
+func TestTLSServer_PKCS12(t *testing.T) {
+	tlss := TLSServer{
+		PKCS12Paths:    []string{"testdata/clicert.pfx"},
+		PKCS12Password: "pass",
+	}
+	if err := tlss.setup(); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := len(tlss.TLSServerConfig().Certificates), 1; g != w {
+		t.Errorf("len(Certificates) got %v, want %v", g, w)
+	}
+}
+
+func TestTLSServer_Errors(t *testing.T) {
+	tt := []TLSServer{
+		{CertPaths: []string{"testdata/listener-a-cert.pem"}},
+		{CertPaths: []string{"testdata/listener-a-cert.pem"}, KeyPaths: []string{"testdata/listener-b-key.pem"}},
+		{PKCS12Paths: []string{"testdata/clicert.pfx"}, PKCS12Password: "wrong"},
+	}
+	for i, te := range tt {
+		if err := te.setup(); err == nil {
+			t.Errorf("%v: want an error, got nil", i)
+		}
+	}
+}
+
+func TestTLSServer_Disabled(t *testing.T) {
+	tlss := TLSServer{}
+	if err := tlss.setup(); err != nil {
+		t.Fatal(err)
+	}
+	if tlss.Enabled() || tlss.TLSServerConfig() != nil {
+		t.Errorf("want disabled, got enabled")
+	}
+	if g := fmt.Sprintf("%s", &tlss); g != "" {
+		t.Errorf("String() got %v, want empty", g)
+	}
+}
+
+func TestTLSServer_ClientAuth(t *testing.T) {
+	tlss := TLSServer{
+		CertPaths:    []string{"testdata/servcert.pem"},
+		KeyPaths:     []string{"testdata/servkey-nopass.pem"},
+		ClientCAPath: "testdata/cacert.pem",
+	}
+	if err := tlss.setup(); err != nil {
+		t.Fatal(err)
+	}
+	if g, w := tlss.ClientAuth, ClientAuthRequire; g != w {
+		t.Errorf("ClientAuth got %v, want %v", g, w)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	serv := httptest.NewUnstartedServer(http.HandlerFunc(ok))
+	serv.TLS = tlss.TLSServerConfig()
+	serv.StartTLS()
+	defer serv.Close()
+
+	tlsc := TLSClient{PKCS12Path: "testdata/clicert.pfx", PKCS12Password: "pass", Insecure: true}
+	if err := tlsc.setup(); err != nil {
+		t.Fatal(err)
+	}
+	withCert := tlsc.TLSClientConfig()
+
+	tt := []struct {
+		name    string
+		cfg     *tls.Config
+		wantErr bool
+	}{
+		{name: "with client cert", cfg: withCert},
+		{name: "without client cert", cfg: &tls.Config{InsecureSkipVerify: true}, wantErr: true},
+	}
+	for _, te := range tt {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: te.cfg}}
+		resp, err := client.Get(serv.URL)
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", te.name, err, w)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func TestTLSServer_ClientAuthErrors(t *testing.T) {
+	tt := []TLSServer{
+		{ClientCAPath: "testdata/cacert.pem"},
+		{CertPaths: []string{"testdata/servcert.pem"}, KeyPaths: []string{"testdata/servkey-nopass.pem"}, ClientAuth: ClientAuthRequire},
+		{CertPaths: []string{"testdata/servcert.pem"}, KeyPaths: []string{"testdata/servkey-nopass.pem"}, ClientCAPath: "testdata/cacert.pem", ClientAuth: "optional"},
+		{CertPaths: []string{"testdata/servcert.pem"}, KeyPaths: []string{"testdata/servkey-nopass.pem"}, ClientCAPath: "testdata/notfound.pem"},
+	}
+	for i, te := range tt {
+		if err := te.setup(); err == nil {
+			t.Errorf("%v: want an error, got nil", i)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Load balancing policies
+const (
+	BalanceRoundRobin       = "round-robin"
+	BalanceRandom           = "random"
+	BalanceLeastOutstanding = "least-outstanding"
+)
+
+// Default values of the Upstream
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 5 * time.Second
+	DefaultFailCooldown        = 30 * time.Second
+)
+
+// Upstream is configuration parameters for the upstream servers of the destination
+type Upstream struct {
+	Balance string // load balancing policy or blank. blank means round-robin
+
+	HealthCheckPath     string        // path for the active health check or blank. blank disables the active health check
+	HealthCheckInterval time.Duration // interval of the active health check or 0. 0 means DefaultHealthCheckInterval
+	HealthCheckTimeout  time.Duration // timeout of the active health check or 0. 0 means DefaultHealthCheckTimeout
+
+	MaxFails     int           // count of the consecutive transport errors to eject the upstream or 0. 0 disables the ejection
+	FailCooldown time.Duration // duration to bring back the ejected upstream or 0. 0 means DefaultFailCooldown
+
+	H2C bool // speaks the cleartext HTTP/2 (h2c) with prior knowledge to the http upstreams. the timeouts other than the dial and the request do not apply
+}
+
+// setup configuration given parameters
+func (u *Upstream) setup() error {
+	switch u.Balance {
+	case "":
+		u.Balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceRandom, BalanceLeastOutstanding:
+	default:
+		return fmt.Errorf("config: unknown load balancing policy %q. must be %v, %v or %v",
+			u.Balance, BalanceRoundRobin, BalanceRandom, BalanceLeastOutstanding)
+	}
+	if len(u.HealthCheckPath) > 0 && !strings.HasPrefix(u.HealthCheckPath, "/") {
+		return fmt.Errorf("config: health check path must start with '/'")
+	}
+	if u.HealthCheckInterval < 0 || u.HealthCheckTimeout < 0 || u.FailCooldown < 0 {
+		return fmt.Errorf("config: health check interval, timeout and fail cooldown must not be negative")
+	}
+	if u.MaxFails < 0 {
+		return fmt.Errorf("config: max fails must not be negative")
+	}
+	if u.HealthCheckInterval == 0 {
+		u.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if u.HealthCheckTimeout == 0 {
+		u.HealthCheckTimeout = DefaultHealthCheckTimeout
+	}
+	if u.FailCooldown == 0 {
+		u.FailCooldown = DefaultFailCooldown
+	}
+	return nil
+}
+
+// String returns string representation of this configuration. useful for debugging.
+func (u *Upstream) String() string {
+	b := strings.Builder{}
+	if u == nil {
+		return b.String()
+	}
+	b.WriteString(fmt.Sprintf("Balance: %s\n", u.Balance))
+	if len(u.HealthCheckPath) > 0 {
+		b.WriteString(fmt.Sprintf("HealthCheck: %s every %v (timeout %v)\n", u.HealthCheckPath, u.HealthCheckInterval, u.HealthCheckTimeout))
+	}
+	if u.MaxFails > 0 {
+		b.WriteString(fmt.Sprintf("MaxFails: %d (cooldown %v)\n", u.MaxFails, u.FailCooldown))
+	}
+	if u.H2C {
+		b.WriteString("H2C: true\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestUpstream(t *testing.T) {
+	tt := []struct {
+		balance string
+		want    string
+		wantErr bool
+	}{
+		{balance: "", want: BalanceRoundRobin},
+		{balance: BalanceRandom, want: BalanceRandom},
+		{balance: BalanceLeastOutstanding, want: BalanceLeastOutstanding},
+		{balance: "unknown", wantErr: true},
+	}
+	for _, te := range tt {
+		u := Upstream{Balance: te.balance}
+		err := u.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", te.balance, err, w)
+			continue
+		}
+		if err == nil && u.Balance != te.want {
+			t.Errorf("%v: Balance got %v, want %v", te.balance, u.Balance, te.want)
+		}
+	}
+}
+
+func TestUpstream_HealthCheck(t *testing.T) {
+	tt := []struct {
+		upstream Upstream
+		wantErr  bool
+	}{
+		{upstream: Upstream{HealthCheckPath: "/health", HealthCheckInterval: time.Second}},
+		{upstream: Upstream{HealthCheckPath: "health", HealthCheckInterval: time.Second}, wantErr: true},
+		{upstream: Upstream{HealthCheckPath: "/health", HealthCheckInterval: -1}, wantErr: true},
+		{upstream: Upstream{MaxFails: 3, FailCooldown: time.Second}},
+		{upstream: Upstream{MaxFails: -1}, wantErr: true},
+	}
+	for i, te := range tt {
+		err := te.upstream.setup()
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", i, err, w)
+		}
+	}
+}
+
+func TestUpstream_Defaults(t *testing.T) {
+	u := Upstream{HealthCheckPath: "/health", MaxFails: 3}
+	if err := u.setup(); err != nil {
+		t.Fatalf("failed to setup: %v", err)
+	}
+	if g, w := u.HealthCheckInterval, DefaultHealthCheckInterval; g != w {
+		t.Errorf("HealthCheckInterval got %v, want %v", g, w)
+	}
+	if g, w := u.HealthCheckTimeout, DefaultHealthCheckTimeout; g != w {
+		t.Errorf("HealthCheckTimeout got %v, want %v", g, w)
+	}
+	if g, w := u.FailCooldown, DefaultFailCooldown; g != w {
+		t.Errorf("FailCooldown got %v, want %v", g, w)
+	}
+}
+
+func TestUpstream_String(t *testing.T) {
+	u := &Upstream{
+		Balance:             BalanceRandom,
+		HealthCheckPath:     "/health",
+		HealthCheckInterval: 10 * time.Second,
+		HealthCheckTimeout:  5 * time.Second,
+		MaxFails:            3,
+		FailCooldown:        30 * time.Second,
+	}
+	got := fmt.Sprintf("%v", u)
+	want := `Balance: random
+HealthCheck: /health every 10s (timeout 5s)
+MaxFails: 3 (cooldown 30s)
+`
+	if g, w := got, want; g != w {
+		t.Errorf("String() got %v, want %v", g, w)
+	}
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"regexp/syntax"
+	"strconv"
 
 	"net/url"
 	"regexp"
@@ -10,8 +12,9 @@ import (
 
 // URL is configuration parameters for the destination
 type URL struct {
-	RewritePaths  map[string]string // map[oldPath]newPath
-	pathRewriters []PathRewriter
+	RewritePaths         map[string]string // map[oldPath]newPath
+	pathRewriters        []PathRewriter
+	reversePathRewriters []PathRewriter
 }
 
 // PathRewriters returns path rewriters
@@ -19,6 +22,12 @@ func (u *URL) PathRewriters() []PathRewriter {
 	return u.pathRewriters
 }
 
+// ReversePathRewriters returns path rewriters which rewrite the new paths back to the old paths.
+// The rewrite rules which can not be inverted are not included.
+func (u *URL) ReversePathRewriters() []PathRewriter {
+	return u.reversePathRewriters
+}
+
 // setup configuration given parameters
 func (u *URL) setup() error {
 	if u == nil {
@@ -31,6 +40,9 @@ func (u *URL) setup() error {
 			return err
 		}
 		u.pathRewriters = append(u.pathRewriters, rwr)
+		if rev, ok := newReverseRewriter(old, new); ok {
+			u.reversePathRewriters = append(u.reversePathRewriters, rev)
+		}
 	}
 	return nil
 }
@@ -86,3 +98,106 @@ func (r *regexpPathRewriter) Do(u *url.URL) bool {
 	u.Path = replaced
 	return true
 }
+
+// newReverseRewriter creates a PathRewriter which rewrites the new path back to the old path.
+// The rule is invertible when the old is a sequence of the literals and the capture groups with the optional anchors,
+// and the new refers to each of the capture groups exactly once by the number.
+// e.g. ^/status_(.+)$ => /status/$1 is inverted to ^/status/(.+)$ => /status_${1}
+func newReverseRewriter(old, new string) (PathRewriter, bool) {
+	re, err := syntax.Parse(old, syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	pieces := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		pieces = re.Sub
+	}
+	var begin, end bool
+	if len(pieces) > 0 && pieces[0].Op == syntax.OpBeginText {
+		begin, pieces = true, pieces[1:]
+	}
+	if len(pieces) > 0 && pieces[len(pieces)-1].Op == syntax.OpEndText {
+		end, pieces = true, pieces[:len(pieces)-1]
+	}
+
+	// capture groups of the old by the number
+	groups := make(map[int]string)
+	for _, p := range pieces {
+		switch {
+		case p.Op == syntax.OpLiteral && p.Flags&syntax.FoldCase == 0:
+		case p.Op == syntax.OpCapture && p.Name == "":
+			groups[p.Cap] = p.Sub[0].String()
+		default:
+			return nil, false
+		}
+	}
+
+	// the new becomes the reverse regexp. the numbers of the groups are renumbered in the order of the appearance
+	rex := strings.Builder{}
+	if begin {
+		rex.WriteString("^")
+	}
+	renumbered := make(map[int]int)
+	for rest := new; rest != ""; {
+		i := strings.IndexByte(rest, '$')
+		if i < 0 {
+			rex.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		rex.WriteString(regexp.QuoteMeta(rest[:i]))
+		rest = rest[i+1:]
+		if strings.HasPrefix(rest, "$") {
+			rex.WriteString(regexp.QuoteMeta("$"))
+			rest = rest[1:]
+			continue
+		}
+		var name string
+		if strings.HasPrefix(rest, "{") {
+			j := strings.IndexByte(rest, '}')
+			if j < 0 {
+				return nil, false
+			}
+			name, rest = rest[1:j], rest[j+1:]
+		} else {
+			j := strings.IndexFunc(rest, func(r rune) bool {
+				return !(r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z')
+			})
+			if j < 0 {
+				j = len(rest)
+			}
+			name, rest = rest[:j], rest[j:]
+		}
+		n, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, false
+		}
+		group, ok := groups[n]
+		if _, dup := renumbered[n]; !ok || dup {
+			return nil, false
+		}
+		renumbered[n] = len(renumbered) + 1
+		rex.WriteString("(" + group + ")")
+	}
+	if end {
+		rex.WriteString("$")
+	}
+	if len(renumbered) != len(groups) {
+		// the value of the group which is not referred is lost
+		return nil, false
+	}
+
+	// the old becomes the replacement
+	repl := strings.Builder{}
+	for _, p := range pieces {
+		if p.Op == syntax.OpLiteral {
+			repl.WriteString(strings.Replace(string(p.Rune), "$", "$$", -1))
+			continue
+		}
+		repl.WriteString("${" + strconv.Itoa(renumbered[p.Cap]) + "}")
+	}
+	rwr, err := newRegexpPathRewriter(rex.String(), repl.String())
+	if err != nil {
+		return nil, false
+	}
+	return rwr, true
+}
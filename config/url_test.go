@@ -89,3 +89,37 @@ func TestRegexpPathRewriter_Do(t *testing.T) {
 		}
 	}
 }
+
+func TestNewReverseRewriter(t *testing.T) {
+	tt := []struct {
+		old, new string
+		path     string
+		want     string
+		wantOK   bool
+	}{
+		{old: "^/status_(.+)$", new: "/status/$1", path: "/status/200", want: "/status_200", wantOK: true},
+		{old: "^/v1/", new: "/", path: "/login", want: "/v1/login", wantOK: true},
+		{old: "^/a/([^/]+)/b/([^/]+)", new: "/b/${2}/a/$1", path: "/b/2/a/1/c", want: "/a/1/b/2/c", wantOK: true},
+		{old: "/price", new: "/$$price", path: "/$price", want: "/price", wantOK: true},
+		{old: "^/users?/", new: "/u/"},
+		{old: "^/(a|b)/", new: "/c/"},
+		{old: "^/a/(.+)/(.+)$", new: "/$1"},
+		{old: "^/a/(.+)$", new: "/$1/$1"},
+		{old: "^/a/(?P<name>.+)$", new: "/${name}"},
+	}
+	for _, te := range tt {
+		rwr, ok := newReverseRewriter(te.old, te.new)
+		if g, w := ok, te.wantOK; g != w {
+			t.Errorf("%v => %v: ok got %v, want %v", te.old, te.new, g, w)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		u := &url.URL{Path: te.path}
+		rwr.Do(u)
+		if g, w := u.Path, te.want; g != w {
+			t.Errorf("%v => %v: reverse of %v got %v, want %v", te.old, te.new, te.path, g, w)
+		}
+	}
+}
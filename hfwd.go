@@ -1,15 +1,14 @@
 package main
 
 import (
-	"log"
 	"os"
 
 	"github.com/kei2100/h-fwd/cli"
 )
 
 func main() {
+	// cobra prints the error
 	if err := cli.RootCmd.Execute(); err != nil {
-		log.Fatal(err)
 		os.Exit(1)
 	}
 }
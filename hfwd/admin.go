@@ -0,0 +1,40 @@
+package hfwd
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// upstreamsReporter is an interface to report the status of the upstreams
+type upstreamsReporter interface {
+	upstreamStatuses() []UpstreamStatus
+}
+
+// NewAdminHandler returns http.Handler for the admin endpoint of the given Handler.
+// GET /upstreams reports the status of the upstreams in JSON.
+func NewAdminHandler(h Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/upstreams", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var ss []UpstreamStatus
+		if rep, ok := h.(upstreamsReporter); ok {
+			ss = rep.upstreamStatuses()
+		}
+		if ss == nil {
+			ss = []UpstreamStatus{}
+		}
+		b, err := json.MarshalIndent(ss, "", "  ")
+		if err != nil {
+			log.Printf("hfwd: failed to marshal the upstream statuses: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+	return mux
+}
@@ -0,0 +1,52 @@
+package hfwd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// setClientCertHeaders sets the headers of the verified client certificate of the orig.
+// The headers of the same names given by the client are removed so that they can not be spoofed.
+func (s *server) setClientCertHeaders(orig, req *http.Request) {
+	params := &s.params.ClientCertHeaders
+	for _, name := range params.ClientCertHeaderNames() {
+		req.Header.Del(name)
+	}
+	if orig.TLS == nil || len(orig.TLS.VerifiedChains) == 0 || len(orig.TLS.VerifiedChains[0]) == 0 {
+		return
+	}
+	cert := orig.TLS.VerifiedChains[0][0]
+	if params.SubjectHeader != "" {
+		req.Header.Set(params.SubjectHeader, cert.Subject.String())
+	}
+	if params.SANHeader != "" {
+		if san := formatSAN(cert); san != "" {
+			req.Header.Set(params.SANHeader, san)
+		}
+	}
+	if params.CertHeader != "" {
+		b := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+		req.Header.Set(params.CertHeader, url.QueryEscape(string(b)))
+	}
+}
+
+// formatSAN formats the subject alternative names of the cert in the form of "DNS:a.example.com, IP:127.0.0.1"
+func formatSAN(cert *x509.Certificate) string {
+	var ss []string
+	for _, n := range cert.DNSNames {
+		ss = append(ss, "DNS:"+n)
+	}
+	for _, n := range cert.EmailAddresses {
+		ss = append(ss, "email:"+n)
+	}
+	for _, n := range cert.IPAddresses {
+		ss = append(ss, "IP:"+n.String())
+	}
+	for _, n := range cert.URIs {
+		ss = append(ss, "URI:"+n.String())
+	}
+	return strings.Join(ss, ", ")
+}
@@ -0,0 +1,106 @@
+package hfwd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func newTestClientCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:   big.NewInt(1),
+		Subject:        pkix.Name{Organization: []string{"Example"}, CommonName: "client"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		DNSNames:       []string{"client.example.com"},
+		EmailAddresses: []string{"client@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestServer_ServeHTTP_ClientCertHeaders(t *testing.T) {
+	dstServer := httptest.NewServer(dstMux)
+	defer dstServer.Close()
+
+	cert := newTestClientCert(t)
+	params := configParam(config.ClientCertHeaders{
+		SubjectHeader: "x-client-cert-subject",
+		SANHeader:     "X-Client-Cert-SAN",
+		CertHeader:    "X-Client-Cert",
+	})
+	h, err := NewHandler(mustURL(dstServer.URL), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	serve := func(state *tls.ConnectionState) http.Header {
+		req := httptest.NewRequest("GET", "/dumpHeaders", nil)
+		req.TLS = state
+		req.Header.Set("X-Client-Cert-Subject", "CN=spoofed")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		b, _ := ioutil.ReadAll(rec.Body)
+		dumpHeaders := make(http.Header)
+		if err := json.Unmarshal(b, &dumpHeaders); err != nil {
+			t.Fatalf("failed to unmarshal %s: %v", b, err)
+		}
+		return dumpHeaders
+	}
+
+	t.Run("verified", func(t *testing.T) {
+		dumpHeaders := serve(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+		if g, w := dumpHeaders.Get("X-Client-Cert-Subject"), "CN=client,O=Example"; g != w {
+			t.Errorf("X-Client-Cert-Subject got %v, want %v", g, w)
+		}
+		if g, w := dumpHeaders.Get("X-Client-Cert-San"), "DNS:client.example.com, email:client@example.com, IP:127.0.0.1"; g != w {
+			t.Errorf("X-Client-Cert-SAN got %v, want %v", g, w)
+		}
+		escaped := dumpHeaders.Get("X-Client-Cert")
+		pemStr, err := url.QueryUnescape(escaped)
+		if err != nil {
+			t.Fatalf("failed to unescape %v: %v", escaped, err)
+		}
+		block, _ := pem.Decode([]byte(pemStr))
+		if block == nil || string(block.Bytes) != string(cert.Raw) {
+			t.Errorf("X-Client-Cert got %v, want the PEM of the client certificate", escaped)
+		}
+	})
+
+	t.Run("not verified", func(t *testing.T) {
+		dumpHeaders := serve(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+		for _, name := range []string{"X-Client-Cert-Subject", "X-Client-Cert-San", "X-Client-Cert"} {
+			if g := dumpHeaders.Get(name); g != "" {
+				t.Errorf("%v got %v, want empty", name, g)
+			}
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package hfwd
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsHeaders are the response headers which are replaced with the own of hfwd in the CORS mode.
+// The Access-Control-Expose-Headers of the upstream is passed through.
+var corsHeaders = []string{
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Max-Age",
+}
+
+// isPreflightRequest reports whether the req is the CORS preflight request
+func isPreflightRequest(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// serveCORS sets the CORS headers for the orig to the w in the CORS mode, and answers the orig if it is the preflight request.
+// It reports whether the orig is answered.
+func (s *server) serveCORS(w http.ResponseWriter, orig *http.Request) bool {
+	params := &s.params.CORS
+	if !params.EnableCORS {
+		return false
+	}
+	h := w.Header()
+	// the caches must not reuse the response for the other origins even if the origin is not allowed
+	if params.VaryOrigin() {
+		h.Add("Vary", "Origin")
+	}
+	allowOrigin := params.AllowOrigin(orig.Header.Get("Origin"))
+	if allowOrigin != "" {
+		h.Set("Access-Control-Allow-Origin", allowOrigin)
+		if params.CORSCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	if !isPreflightRequest(orig) {
+		return false
+	}
+
+	// the browser rejects the preflight response without the Access-Control-Allow-Origin
+	if allowOrigin != "" {
+		methods := strings.Join(params.CORSMethods, ", ")
+		if params.CORSReflect {
+			methods = orig.Header.Get("Access-Control-Request-Method")
+		}
+		h.Set("Access-Control-Allow-Methods", methods)
+		headers := strings.Join(orig.Header["Access-Control-Request-Headers"], ", ")
+		if len(params.CORSHeaders) > 0 {
+			headers = strings.Join(params.CORSHeaders, ", ")
+		}
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		}
+		if params.CORSMaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(params.CORSMaxAge/time.Second), 10))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// removeCORSHeaders removes the CORS headers of the upstream from the res in the CORS mode
+func (s *server) removeCORSHeaders(res *http.Response) {
+	if !s.params.EnableCORS {
+		return
+	}
+	for _, name := range corsHeaders {
+		res.Header.Del(name)
+	}
+}
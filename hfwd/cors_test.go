@@ -0,0 +1,117 @@
+package hfwd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_serveCORS(t *testing.T) {
+	var forwarded []string
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Method)
+		w.Header().Set("Access-Control-Allow-Origin", "https://upstream.example.com")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Total-Count")
+	}))
+	defer dstServer.Close()
+
+	do := func(t *testing.T, proxyURL, method string, header http.Header) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, proxyURL, nil)
+		req.Header = header
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to %v: %v", method, err)
+		}
+		res.Body.Close()
+		return res
+	}
+	preflight := http.Header{
+		"Origin":                         {"http://localhost:3000"},
+		"Access-Control-Request-Method":  {"PUT"},
+		"Access-Control-Request-Headers": {"X-Custom, Content-Type"},
+	}
+
+	t.Run("allowed origin", func(t *testing.T) {
+		forwarded = nil
+		params := configParam(config.CORS{
+			EnableCORS:      true,
+			CORSOrigins:     []string{"http://localhost:3000"},
+			CORSCredentials: true,
+			CORSMaxAge:      10 * time.Minute,
+		})
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			res := do(t, proxyURL, "OPTIONS", preflight)
+			if g, w := res.StatusCode, http.StatusNoContent; g != w {
+				t.Errorf("preflight res.StatusCode got %v, want %v", g, w)
+			}
+			want := map[string]string{
+				"Access-Control-Allow-Origin":      "http://localhost:3000",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Allow-Methods":     "GET, HEAD, POST, PUT, PATCH, DELETE",
+				"Access-Control-Allow-Headers":     "X-Custom, Content-Type",
+				"Access-Control-Max-Age":           "600",
+				"Vary":                             "Origin",
+			}
+			for name, w := range want {
+				if g := res.Header.Get(name); g != w {
+					t.Errorf("preflight %v got %v, want %v", name, g, w)
+				}
+			}
+			if len(forwarded) != 0 {
+				t.Errorf("the preflight is forwarded: %v", forwarded)
+			}
+
+			res = do(t, proxyURL, "GET", http.Header{"Origin": {"http://localhost:3000"}})
+			if g, w := res.Header["Access-Control-Allow-Origin"], []string{"http://localhost:3000"}; len(g) != 1 || g[0] != w[0] {
+				t.Errorf("Access-Control-Allow-Origin got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Access-Control-Expose-Headers"), "X-Total-Count"; g != w {
+				t.Errorf("Access-Control-Expose-Headers got %v, want %v", g, w)
+			}
+
+			// the OPTIONS which is not the preflight is forwarded
+			do(t, proxyURL, "OPTIONS", http.Header{})
+			if g, w := len(forwarded), 2; g != w {
+				t.Errorf("forwarded requests got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("disallowed origin", func(t *testing.T) {
+		params := configParam(config.CORS{EnableCORS: true, CORSOrigins: []string{"http://localhost:3000"}})
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			header := http.Header{"Origin": {"http://evil.example.com"}, "Access-Control-Request-Method": {"PUT"}}
+			res := do(t, proxyURL, "OPTIONS", header)
+			if g := res.Header.Get("Access-Control-Allow-Origin"); g != "" {
+				t.Errorf("preflight Access-Control-Allow-Origin got %v, want blank", g)
+			}
+			if g, w := res.Header.Get("Vary"), "Origin"; g != w {
+				t.Errorf("preflight Vary got %v, want %v", g, w)
+			}
+			res = do(t, proxyURL, "GET", http.Header{"Origin": {"http://evil.example.com"}})
+			if g := res.Header.Get("Access-Control-Allow-Origin"); g != "" {
+				t.Errorf("Access-Control-Allow-Origin got %v, want blank", g)
+			}
+			if g, w := res.Header.Get("Vary"), "Origin"; g != w {
+				t.Errorf("Vary got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("reflect", func(t *testing.T) {
+		params := configParam(config.CORS{EnableCORS: true, CORSReflect: true})
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			res := do(t, proxyURL, "OPTIONS", preflight)
+			if g, w := res.Header.Get("Access-Control-Allow-Origin"), "http://localhost:3000"; g != w {
+				t.Errorf("Access-Control-Allow-Origin got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Access-Control-Allow-Methods"), "PUT"; g != w {
+				t.Errorf("Access-Control-Allow-Methods got %v, want %v", g, w)
+			}
+		})
+	})
+}
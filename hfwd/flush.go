@@ -0,0 +1,103 @@
+package hfwd
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// flushInterval returns the interval of flushing the res to the client.
+// Negative means flushing after each read, 0 means no flushing.
+func (s *server) flushInterval(res *http.Response) time.Duration {
+	if ct, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); ct == "text/event-stream" {
+		return -1
+	}
+	if res.ContentLength == -1 {
+		return -1
+	}
+	return s.params.FlushInterval
+}
+
+// copyResponseBody copies the body to the w, and flushes the w at the interval
+func copyResponseBody(w http.ResponseWriter, body io.Reader, interval time.Duration) error {
+	f, ok := w.(http.Flusher)
+	if !ok || interval == 0 {
+		_, err := io.Copy(w, body)
+		return err
+	}
+	if interval < 0 {
+		// the headers are sent before the first read which may block for a long time
+		f.Flush()
+		_, err := io.Copy(&flushWriter{w: w, f: f}, body)
+		return err
+	}
+	mw := &maxLatencyWriter{w: w, f: f, latency: interval}
+	defer mw.stop()
+	_, err := io.Copy(mw, body)
+	return err
+}
+
+// flushWriter flushes after each write
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if n > 0 {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+// maxLatencyWriter flushes the written bytes within the latency
+type maxLatencyWriter struct {
+	w       io.Writer
+	f       http.Flusher
+	latency time.Duration
+
+	mu      sync.Mutex // guards the writes, the flushes and the fields below
+	t       *time.Timer
+	pending bool
+	stopped bool
+}
+
+func (mw *maxLatencyWriter) Write(p []byte) (int, error) {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	n, err := mw.w.Write(p)
+	if mw.pending {
+		return n, err
+	}
+	mw.pending = true
+	if mw.t == nil {
+		mw.t = time.AfterFunc(mw.latency, mw.delayedFlush)
+	} else {
+		mw.t.Reset(mw.latency)
+	}
+	return n, err
+}
+
+func (mw *maxLatencyWriter) delayedFlush() {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	// the stopped writer must not be flushed since the handler may have returned
+	if !mw.pending || mw.stopped {
+		return
+	}
+	mw.f.Flush()
+	mw.pending = false
+}
+
+func (mw *maxLatencyWriter) stop() {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+	mw.stopped = true
+	mw.pending = false
+	if mw.t != nil {
+		mw.t.Stop()
+	}
+}
@@ -0,0 +1,120 @@
+package hfwd
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_ServeHTTP_Flush(t *testing.T) {
+	// stream writes the first line and blocks until the release
+	stream := func(contentType string, contentLength string, release <-chan struct{}) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			if contentLength != "" {
+				w.Header().Set("Content-Length", contentLength)
+			}
+			io.WriteString(w, "first\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			io.WriteString(w, "last\n")
+		})
+	}
+
+	tt := []struct {
+		name          string
+		contentType   string
+		contentLength string
+		streaming     config.Streaming
+		verbose       bool
+	}{
+		{name: "event stream", contentType: "text/event-stream", contentLength: "11"},
+		{name: "event stream in verbose", contentType: "text/event-stream", contentLength: "11", verbose: true},
+		{name: "unknown length", contentType: "text/plain"},
+		{name: "flush interval", contentType: "text/plain", contentLength: "11", streaming: config.Streaming{FlushInterval: 10 * time.Millisecond}},
+		{name: "flush after each read", contentType: "text/plain", contentLength: "11", streaming: config.Streaming{FlushInterval: -1}},
+	}
+	for _, te := range tt {
+		t.Run(te.name, func(t *testing.T) {
+			release := make(chan struct{})
+			dstServer := httptest.NewServer(stream(te.contentType, te.contentLength, release))
+			defer dstServer.Close()
+
+			params := configParam(te.streaming)
+			params.Verbose = te.verbose
+			withRunProxy(dstServer.URL, params, func(proxyURL string) {
+				defer close(release)
+
+				lines := make(chan string, 1)
+				go func() {
+					res, err := http.Get(proxyURL)
+					if err != nil {
+						t.Errorf("failed to GET: %v", err)
+						lines <- ""
+						return
+					}
+					defer res.Body.Close()
+					line, _ := bufio.NewReader(res.Body).ReadString('\n')
+					lines <- line
+				}()
+				select {
+				case line := <-lines:
+					if g, w := line, "first\n"; g != w {
+						t.Errorf("line got %q, want %q", g, w)
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("the first line is not flushed")
+				}
+			})
+		})
+	}
+}
+
+func TestServer_ServeHTTP_StreamRequestBody(t *testing.T) {
+	// received receives the first line of the request body before the rest is sent
+	received := make(chan string, 1)
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		line, _ := bufio.NewReader(r.Body).ReadString('\n')
+		received <- line
+		ioutil.ReadAll(r.Body)
+	}))
+	defer dstServer.Close()
+
+	params := configParam()
+	params.Verbose = true
+	withRunProxy(dstServer.URL, params, func(proxyURL string) {
+		pr, pw := io.Pipe()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			res, err := http.Post(proxyURL, "text/plain", pr)
+			if err != nil {
+				t.Errorf("failed to POST: %v", err)
+				return
+			}
+			res.Body.Close()
+		}()
+		io.WriteString(pw, "first\n")
+		select {
+		case line := <-received:
+			if g, w := line, "first\n"; g != w {
+				t.Errorf("line got %q, want %q", g, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("the request body is not streamed")
+		}
+		pw.Close()
+		<-done
+	})
+}
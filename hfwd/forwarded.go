@@ -0,0 +1,105 @@
+package hfwd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// viaPseudonym is the received-by of the Via header
+const viaPseudonym = "hfwd"
+
+// setForwardedHeaders sets the headers which record the client of the orig and this hop according to the modes.
+// The incoming values are honoured in the append mode only when the client is one of the trusted proxies.
+func (s *server) setForwardedHeaders(orig, req *http.Request) {
+	params := &s.params.ForwardedHeaders
+	clientIP, _, err := net.SplitHostPort(orig.RemoteAddr)
+	if err != nil {
+		clientIP = orig.RemoteAddr
+	}
+	trusted := params.IsTrustedProxy(net.ParseIP(clientIP))
+	proto := "http"
+	if orig.TLS != nil {
+		proto = "https"
+	}
+
+	switch params.XForwarded {
+	case config.ForwardedModeStrip:
+		req.Header.Del("X-Forwarded-For")
+		req.Header.Del("X-Forwarded-Proto")
+		req.Header.Del("X-Forwarded-Host")
+	case config.ForwardedModeAppend, config.ForwardedModeReplace:
+		keep := params.XForwarded == config.ForwardedModeAppend && trusted
+		setForwardedValue(req.Header, "X-Forwarded-For", clientIP, keep, true)
+		setForwardedValue(req.Header, "X-Forwarded-Proto", proto, keep, false)
+		setForwardedValue(req.Header, "X-Forwarded-Host", orig.Host, keep, false)
+	}
+
+	switch params.Forwarded {
+	case config.ForwardedModeStrip:
+		req.Header.Del("Forwarded")
+	case config.ForwardedModeAppend, config.ForwardedModeReplace:
+		keep := params.Forwarded == config.ForwardedModeAppend && trusted
+		elem := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedNode(clientIP), forwardedValue(orig.Host), proto)
+		setForwardedValue(req.Header, "Forwarded", elem, keep, true)
+	}
+
+	switch params.Via {
+	case config.ForwardedModeStrip:
+		req.Header.Del("Via")
+	case config.ForwardedModeAppend, config.ForwardedModeReplace:
+		// the own entry is always appended. the trusted check decides only whether the incoming entries are kept
+		var entries []string
+		if params.Via == config.ForwardedModeAppend && trusted {
+			entries = req.Header["Via"]
+		}
+		entries = append(entries[:len(entries):len(entries)], fmt.Sprintf("%d.%d %s", orig.ProtoMajor, orig.ProtoMinor, viaPseudonym))
+		req.Header.Set("Via", strings.Join(entries, ", "))
+	}
+}
+
+// setForwardedValue sets the v to the header name.
+// When the keep is true, the list header appends the v to the incoming values, and the other header keeps the incoming value if exists.
+func setForwardedValue(h http.Header, name, v string, keep, list bool) {
+	prior := h[name]
+	switch {
+	case keep && len(prior) > 0 && list:
+		h.Set(name, strings.Join(prior, ", ")+", "+v)
+	case keep && len(prior) > 0:
+	default:
+		h.Set(name, v)
+	}
+}
+
+// forwardedNode returns the node of the Forwarded header for the ip.
+// https://tools.ietf.org/html/rfc7239#section-6
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		// IPv6
+		return `"[` + ip + `]"`
+	}
+	return forwardedValue(ip)
+}
+
+// forwardedValue returns the v as the token, or the quoted-string if the v contains the other characters
+func forwardedValue(v string) string {
+	for _, c := range v {
+		if !isTokenChar(c) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+// isTokenChar reports whether the c is tchar of the RFC 7230.
+// https://tools.ietf.org/html/rfc7230#section-3.2.6
+func isTokenChar(c rune) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
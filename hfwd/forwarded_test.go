@@ -0,0 +1,111 @@
+package hfwd
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_setForwardedHeaders(t *testing.T) {
+	incoming := http.Header{
+		"X-Forwarded-For":   {"203.0.113.1"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"www.example.com"},
+		"Forwarded":         {"for=203.0.113.1;host=www.example.com;proto=https"},
+		"Via":               {"1.1 edge"},
+	}
+
+	tt := []struct {
+		name       string
+		params     config.ForwardedHeaders
+		remoteAddr string
+		tls        bool
+		want       http.Header
+	}{
+		{
+			name:       "pass through",
+			remoteAddr: "192.0.2.1:1234",
+			want:       incoming,
+		},
+		{
+			name:       "append from the trusted proxy",
+			params:     config.ForwardedHeaders{XForwarded: "append", Forwarded: "append", Via: "append", TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.1:1234",
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1, 192.0.2.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"www.example.com"},
+				"Forwarded":         {`for=203.0.113.1;host=www.example.com;proto=https, for=192.0.2.1;host="proxy.localhost:8080";proto=http`},
+				"Via":               {"1.1 edge, 1.1 hfwd"},
+			},
+		},
+		{
+			name:       "append from the untrusted client",
+			params:     config.ForwardedHeaders{XForwarded: "append", Forwarded: "append", Via: "append", TrustedProxies: []string{"192.0.2.1"}},
+			remoteAddr: "[2001:db8::1]:1234",
+			tls:        true,
+			want: http.Header{
+				"X-Forwarded-For":   {"2001:db8::1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"proxy.localhost:8080"},
+				"Forwarded":         {`for="[2001:db8::1]";host="proxy.localhost:8080";proto=https`},
+				"Via":               {"1.1 hfwd"},
+			},
+		},
+		{
+			name:       "append the via without the trusted proxies",
+			params:     config.ForwardedHeaders{Via: "append"},
+			remoteAddr: "192.0.2.1:1234",
+			want: http.Header{
+				"X-Forwarded-For":   {"203.0.113.1"},
+				"X-Forwarded-Proto": {"https"},
+				"X-Forwarded-Host":  {"www.example.com"},
+				"Forwarded":         {"for=203.0.113.1;host=www.example.com;proto=https"},
+				"Via":               {"1.1 hfwd"},
+			},
+		},
+		{
+			name:       "replace",
+			params:     config.ForwardedHeaders{XForwarded: "replace", Forwarded: "replace", Via: "replace", TrustedProxies: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.1:1234",
+			want: http.Header{
+				"X-Forwarded-For":   {"192.0.2.1"},
+				"X-Forwarded-Proto": {"http"},
+				"X-Forwarded-Host":  {"proxy.localhost:8080"},
+				"Forwarded":         {`for=192.0.2.1;host="proxy.localhost:8080";proto=http`},
+				"Via":               {"1.1 hfwd"},
+			},
+		},
+		{
+			name:       "strip",
+			params:     config.ForwardedHeaders{XForwarded: "strip", Forwarded: "strip", Via: "strip"},
+			remoteAddr: "192.0.2.1:1234",
+			want:       http.Header{},
+		},
+	}
+	for _, te := range tt {
+		t.Run(te.name, func(t *testing.T) {
+			s := &server{params: configParam(te.params)}
+			orig := httptest.NewRequest("GET", "http://proxy.localhost:8080/", nil)
+			orig.RemoteAddr = te.remoteAddr
+			if te.tls {
+				orig.TLS = &tls.ConnectionState{}
+			}
+			for k, v := range incoming {
+				orig.Header[k] = v
+			}
+			req := httptest.NewRequest("GET", "http://upstream.localhost/", nil)
+			s.copyHeader(orig, req)
+			s.setForwardedHeaders(orig, req)
+
+			for _, name := range []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host", "Forwarded", "Via"} {
+				if g, w := req.Header.Get(name), te.want.Get(name); g != w {
+					t.Errorf("%v got %v, want %v", name, g, w)
+				}
+			}
+		})
+	}
+}
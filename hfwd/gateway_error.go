@@ -0,0 +1,139 @@
+package hfwd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// Stages of the forwarding where the error occurred
+const (
+	stageUpstream  = "upstream"  // selecting the upstream
+	stageDNS       = "dns"       // resolving the upstream host
+	stageConnect   = "connect"   // connecting to the upstream
+	stageTLS       = "tls"       // TLS handshake with the upstream
+	stageTransport = "transport" // sending the request or receiving the response
+)
+
+// stageMessages are the messages of the stages sent to the client instead of the raw errors
+var stageMessages = map[string]string{
+	stageDNS:       "failed to resolve the upstream host",
+	stageConnect:   "failed to connect to the upstream",
+	stageTLS:       "failed to handshake TLS with the upstream",
+	stageTransport: "failed to forward the request to the upstream",
+}
+
+// tlsHandshakeTimeoutMessage is the message of the TLS handshake timeout of the http.Transport, whose type is unexported
+const tlsHandshakeTimeoutMessage = "net/http: TLS handshake timeout"
+
+// gatewayError is an error occurred while forwarding the request to the upstream
+type gatewayError struct {
+	Status      int    `json:"status"`
+	Stage       string `json:"stage"`
+	Destination string `json:"destination,omitempty"`
+	Message     string `json:"error"`
+}
+
+// newGatewayError classifies the error returned from the forwarder
+// into 502 Bad Gateway or 504 Gateway Timeout and the failing stage.
+// The destination and the raw error, which reveal the upstream addresses, are set only if the verbose.
+func newGatewayError(dst *url.URL, err error, verbose bool) *gatewayError {
+	e := classifyError(err)
+	if verbose {
+		e.Destination = redactURL(dst)
+		e.Message = err.Error()
+	} else {
+		e.Message = stageMessages[e.Stage]
+	}
+	return e
+}
+
+// classifyError returns the gatewayError of the status and the stage of the err
+func classifyError(err error) *gatewayError {
+	e := &gatewayError{
+		Status: http.StatusBadGateway,
+		Stage:  stageTransport,
+	}
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	if err == context.DeadlineExceeded {
+		e.Status = http.StatusGatewayTimeout
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		e.Status = http.StatusGatewayTimeout
+	}
+	if oe, ok := err.(*net.OpError); ok {
+		switch oe.Op {
+		case "dial":
+			e.Stage = stageConnect
+		case "remote error", "local error":
+			// the alerts of the TLS
+			e.Stage = stageTLS
+		}
+		err = oe.Err
+	}
+	if _, ok := err.(*net.DNSError); ok {
+		e.Stage = stageDNS
+	}
+	if isTLSError(err) {
+		e.Stage = stageTLS
+	}
+	return e
+}
+
+// isTLSError reports whether the err is the failure of the TLS handshake
+func isTLSError(err error) bool {
+	switch err.(type) {
+	case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError, x509.SystemRootsError,
+		x509.ConstraintViolationError, x509.UnhandledCriticalExtension, x509.InsecureAlgorithmError, tls.RecordHeaderError:
+		return true
+	}
+	return isCertificateVerificationError(err) || err.Error() == tlsHandshakeTimeoutMessage
+}
+
+// redactURL returns the URL string without the user info
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	uu := *u
+	uu.User = nil
+	return uu.String()
+}
+
+// writeError writes the error response in the format of the params
+func writeError(w http.ResponseWriter, params *config.ErrorResponse, e *gatewayError) {
+	switch params.ErrorFormat {
+	case config.ErrorFormatJSON:
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("hfwd: failed to marshal the error response: %v", err)
+			w.WriteHeader(e.Status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(e.Status)
+		w.Write(b)
+	case config.ErrorFormatText, "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(e.Status)
+		fmt.Fprintf(w, "hfwd: %v: %v failed", http.StatusText(e.Status), e.Stage)
+		if e.Destination != "" {
+			fmt.Fprintf(w, " for %v", e.Destination)
+		}
+		fmt.Fprintf(w, ": %v\n", e.Message)
+	default:
+		w.WriteHeader(e.Status)
+	}
+}
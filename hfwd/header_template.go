@@ -0,0 +1,86 @@
+package hfwd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestIDHeader is the header of the request ID which is passed through as the {request_id}
+const requestIDHeader = "X-Request-Id"
+
+// placeholderRegexp matches the placeholders and the escaped braces "{{" in the header values.
+// The other braces such as the JSON in the values are left as they are.
+var placeholderRegexp = regexp.MustCompile(`\{\{|\{(client_ip|host|request_id|time|time_unix|env:[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// headerTemplate expands the placeholders in the header values for the orig request.
+//
+//	{client_ip}  IP of the client
+//	{host}       original Host of the request
+//	{request_id} X-Request-Id of the request, or the random ID if the request has none
+//	{time}       time when the request is received in RFC 3339
+//	{time_unix}  time when the request is received in the unix seconds
+//	{env:NAME}   environment variable NAME
+//	{{           literal "{", such as "{{host}" for "{host}"
+type headerTemplate struct {
+	orig      *http.Request
+	received  time.Time
+	requestID string
+}
+
+func newHeaderTemplate(orig *http.Request) *headerTemplate {
+	return &headerTemplate{orig: orig, received: time.Now()}
+}
+
+// expand replaces the placeholders in the v
+func (t *headerTemplate) expand(v string) string {
+	if !strings.Contains(v, "{") {
+		return v
+	}
+	return placeholderRegexp.ReplaceAllStringFunc(v, func(ph string) string {
+		if ph == "{{" {
+			return "{"
+		}
+		switch name := ph[1 : len(ph)-1]; name {
+		case "client_ip":
+			ip, _, err := net.SplitHostPort(t.orig.RemoteAddr)
+			if err != nil {
+				return t.orig.RemoteAddr
+			}
+			return ip
+		case "host":
+			return t.orig.Host
+		case "request_id":
+			return t.getRequestID()
+		case "time":
+			return t.received.Format(time.RFC3339)
+		case "time_unix":
+			return strconv.FormatInt(t.received.Unix(), 10)
+		default:
+			return os.Getenv(strings.TrimPrefix(name, "env:"))
+		}
+	})
+}
+
+// getRequestID returns the same request ID for the request, the retries of the request and the response
+func (t *headerTemplate) getRequestID() string {
+	if t.requestID != "" {
+		return t.requestID
+	}
+	if t.requestID = t.orig.Header.Get(requestIDHeader); t.requestID != "" {
+		return t.requestID
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		t.requestID = strconv.FormatInt(t.received.UnixNano(), 36)
+		return t.requestID
+	}
+	t.requestID = hex.EncodeToString(b)
+	return t.requestID
+}
@@ -0,0 +1,58 @@
+package hfwd
+
+import (
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHeaderTemplate_expand(t *testing.T) {
+	os.Setenv("HFWD_TEST_ENV", "env")
+	defer os.Unsetenv("HFWD_TEST_ENV")
+
+	orig := httptest.NewRequest("GET", "http://www.example.com/", nil)
+	orig.RemoteAddr = "192.0.2.1:54321"
+	tmpl := newHeaderTemplate(orig)
+	tmpl.received = time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tt := []struct {
+		v    string
+		want string
+	}{
+		{v: "static", want: "static"},
+		{v: "{client_ip}", want: "192.0.2.1"},
+		{v: "for={client_ip};host={host}", want: "for=192.0.2.1;host=www.example.com"},
+		{v: "{time}", want: "2018-10-01T12:00:00Z"},
+		{v: "{time_unix}", want: strconv.FormatInt(tmpl.received.Unix(), 10)},
+		{v: "{env:HFWD_TEST_ENV}", want: "env"},
+		{v: "{env:HFWD_TEST_UNDEFINED}", want: ""},
+		{v: `{"unknown": "{unknown}"}`, want: `{"unknown": "{unknown}"}`},
+		{v: "{{client_ip}", want: "{client_ip}"},
+		{v: "{{{client_ip}}", want: "{192.0.2.1}"},
+		{v: "{{{{", want: "{{"},
+	}
+	for _, te := range tt {
+		if g, w := tmpl.expand(te.v), te.want; g != w {
+			t.Errorf("expand(%q) got %v, want %v", te.v, g, w)
+		}
+	}
+
+	t.Run("request_id", func(t *testing.T) {
+		id := tmpl.expand("{request_id}")
+		if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id) {
+			t.Errorf("request_id got %v, want 32 hex digits", id)
+		}
+		if g, w := tmpl.expand("{request_id}"), id; g != w {
+			t.Errorf("request_id got %v, want the same %v", g, w)
+		}
+
+		orig := httptest.NewRequest("GET", "http://www.example.com/", nil)
+		orig.Header.Set("X-Request-Id", "incoming")
+		if g, w := newHeaderTemplate(orig).expand("{request_id}"), "incoming"; g != w {
+			t.Errorf("request_id got %v, want %v", g, w)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package hfwd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+)
+
+// healthChecker performs the active health check of the upstreams periodically
+type healthChecker struct {
+	server   *server
+	client   *http.Client
+	path     string
+	interval time.Duration
+	timeout  time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newHealthChecker(s *server, tran http.RoundTripper) *healthChecker {
+	params := s.params.Upstream
+	return &healthChecker{
+		server:   s,
+		client:   &http.Client{Transport: tran},
+		path:     params.HealthCheckPath,
+		interval: params.HealthCheckInterval,
+		timeout:  params.HealthCheckTimeout,
+		stop:     make(chan struct{}),
+	}
+}
+
+// start the health check for each upstream
+func (hc *healthChecker) start() {
+	for _, u := range hc.server.upstreams.all {
+		hc.wg.Add(1)
+		go hc.run(u)
+	}
+}
+
+// close stops the health check and waits for the running checks
+func (hc *healthChecker) close() {
+	close(hc.stop)
+	hc.wg.Wait()
+}
+
+func (hc *healthChecker) run(u *upstream) {
+	defer hc.wg.Done()
+	t := time.NewTicker(hc.interval)
+	defer t.Stop()
+	for {
+		hc.check(u)
+		select {
+		case <-hc.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (hc *healthChecker) check(u *upstream) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if hc.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), hc.timeout)
+	}
+	defer cancel()
+
+	checkURL := *u.url
+	checkURL.Path = path.Join(checkURL.Path, hc.path)
+	req, err := http.NewRequest("GET", checkURL.String(), nil)
+	if err != nil {
+		u.setHealthy(false, err.Error())
+		return
+	}
+	// the health check has no client. {client_ip} is expanded to the blank
+	hc.server.rewriteHeader(req, newHeaderTemplate(req))
+	res, err := hc.client.Do(req.WithContext(ctx))
+	if err != nil {
+		u.setHealthy(false, err.Error())
+		return
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		u.setHealthy(false, fmt.Sprintf("status %v", res.StatusCode))
+		return
+	}
+	u.setHealthy(true, "")
+}
@@ -0,0 +1,134 @@
+package hfwd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var dst2Healthy int32 = 1
+	dst1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("dst1"))
+	}))
+	defer dst1.Close()
+	dst2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/base/health" && atomic.LoadInt32(&dst2Healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("dst2"))
+	}))
+	defer dst2.Close()
+
+	params := configParam(config.Upstream{HealthCheckPath: "/health", HealthCheckInterval: 10 * time.Millisecond})
+	h, err := NewLoadBalancingHandler([]*url.URL{mustURL(dst1.URL + "/base"), mustURL(dst2.URL + "/base")}, params)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer h.Close()
+	s := h.(*server)
+
+	atomic.StoreInt32(&dst2Healthy, 0)
+	waitUntil(t, func() bool { return !s.upstreams.all[1].available(time.Now()) })
+	for i := 0; i < 4; i++ {
+		if g, w := s.upstreams.next(), s.upstreams.all[0]; g != w {
+			t.Errorf("%v: got %v, want %v", i, g.url, w.url)
+		}
+	}
+
+	atomic.StoreInt32(&dst2Healthy, 1)
+	waitUntil(t, func() bool { return s.upstreams.all[1].available(time.Now()) })
+}
+
+func TestPassiveHealthCheck(t *testing.T) {
+	dst := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer dst.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	params := configParam(config.Upstream{MaxFails: 2, FailCooldown: 50 * time.Millisecond})
+	h, err := NewLoadBalancingHandler([]*url.URL{mustURL(closed.URL), mustURL(dst.URL)}, params)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer h.Close()
+	proxyServer := httptest.NewServer(h)
+	defer proxyServer.Close()
+	s := h.(*server)
+
+	// round-robin: closed, dst, closed (ejected), dst, dst, ...
+	for i := 0; i < 6; i++ {
+		res, err := http.Get(proxyServer.URL)
+		if err != nil {
+			t.Fatalf("failed to GET: %v", err)
+		}
+		res.Body.Close()
+	}
+	if s.upstreams.all[0].available(time.Now()) {
+		t.Errorf("the closed upstream is available, want ejected")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !s.upstreams.all[0].available(time.Now()) {
+		t.Errorf("the closed upstream is not available, want brought back after the cooldown")
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	r := &config.Route{
+		PathPrefix:  "/api",
+		Destination: "http://upstream1.example.com,http://upstream2.example.com",
+	}
+	if err := r.Setup(); err != nil {
+		t.Fatalf("failed to setup route: %v", err)
+	}
+	h, err := NewRouter([]*config.Route{r})
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	defer h.Close()
+
+	adminServer := httptest.NewServer(NewAdminHandler(h))
+	defer adminServer.Close()
+	res, err := http.Get(adminServer.URL + "/upstreams")
+	assertOKResponse(t, res, err)
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+
+	var ss []UpstreamStatus
+	if err := json.Unmarshal(b, &ss); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", b, err)
+	}
+	if g, w := len(ss), 2; g != w {
+		t.Fatalf("len(statuses) got %v, want %v", g, w)
+	}
+	if g, w := ss[1].URL, "http://upstream2.example.com"; g != w {
+		t.Errorf("URL got %v, want %v", g, w)
+	}
+	if g, w := ss[1].Route, "/api"; g != w {
+		t.Errorf("Route got %v, want %v", g, w)
+	}
+	if !ss[1].Available || !ss[1].Healthy {
+		t.Errorf("got %+v, want available and healthy", ss[1])
+	}
+}
+
+func waitUntil(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
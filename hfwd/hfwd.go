@@ -1,7 +1,10 @@
 package hfwd
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/http"
 	"net/url"
 
@@ -15,108 +18,339 @@ import (
 	"fmt"
 
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
 	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/dsnet/compress/brotli"
 	"github.com/kei2100/h-fwd/config"
+	"golang.org/x/net/http2"
 )
 
-// NewHandler returns http.Handler which performs forward proxy.
-func NewHandler(dst *url.URL, params *config.Parameters) (http.Handler, error) {
-	if err := validateDestinatin(dst); err != nil {
+// Handler is http.Handler which performs forward proxy.
+type Handler interface {
+	http.Handler
+	// Close stops the background tasks of this Handler such as the health check
+	Close() error
+}
+
+// NewHandler returns Handler which performs forward proxy.
+func NewHandler(dst *url.URL, params *config.Parameters) (Handler, error) {
+	return NewLoadBalancingHandler([]*url.URL{dst}, params)
+}
+
+// NewLoadBalancingHandler returns Handler which performs forward proxy.
+// The requests are spread across the given destinations according to the load balancing policy of the params.
+func NewLoadBalancingHandler(dsts []*url.URL, params *config.Parameters) (Handler, error) {
+	if len(dsts) == 0 {
+		return nil, errors.New("hfwd: requires at least one destination URL")
+	}
+	for _, dst := range dsts {
+		if err := validateDestinatin(dst); err != nil {
+			return nil, err
+		}
+		if params.H2C && dst.Scheme != "http" {
+			return nil, errors.New("hfwd: h2c requires the http destination URL")
+		}
+	}
+	ups, err := newUpstreams(dsts, &params.Upstream)
+	if err != nil {
 		return nil, err
 	}
-	var tran http.RoundTripper
-	tran = &http.Transport{TLSClientConfig: params.TLSClientConfig()}
+	dialer := &net.Dialer{
+		Timeout:   params.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	tlsConfig := params.TLSClientConfig()
+	if tlsConfig != nil {
+		// the http2.ConfigureTransport modifies the NextProtos
+		tlsConfig = tlsConfig.Clone()
+	}
+	h1 := &http.Transport{
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   params.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: params.ResponseHeaderTimeout,
+		IdleConnTimeout:       params.IdleConnTimeout,
+		// waits for the 100 Continue from the upstream before reading the request body,
+		// so that the http.Server sends the 100 Continue to the client as the upstream does
+		ExpectContinueTimeout: expectContinueTimeout,
+	}
+	var base transport = h1
+	if params.H2C {
+		// the http2.Transport of the h2c does not refer the settings of the h1,
+		// so that the TLSHandshakeTimeout, ResponseHeaderTimeout and IdleConnTimeout do not apply.
+		// the x/net in use has no ReadIdleTimeout and PingTimeout either. the RequestTimeout bounds the whole request
+		base = &http2.Transport{
+			AllowHTTP: true,
+			// the DialTLS has no context of the request. the dial is bounded by the DialTimeout of the dialer
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(context.Background(), network, addr)
+			},
+		}
+	} else if err := http2.ConfigureTransport(h1); err != nil {
+		// HTTP/2 is negotiated by the TLS ALPN with the https upstreams
+		return nil, fmt.Errorf("hfwd: failed to setup HTTP/2 transport: %v", err)
+	}
+	var tran http.RoundTripper = base
 	if params.Verbose {
-		log.Printf("hfwd destination is %v", dst.String())
-		log.Printf("hfwd configuration parameters are\n%s", params)
-		tran = &verboseRoundTripper{
-			chain: &http.Transport{TLSClientConfig: params.TLSClientConfig()},
+		for _, dst := range dsts {
+			log.Printf("hfwd destination is %v", dst.String())
 		}
+		log.Printf("hfwd configuration parameters are\n%s", params)
+		tran = &verboseRoundTripper{chain: base}
 	}
 	forwarder := &http.Client{
 		Transport: tran,
+		// the redirects are returned to the client as they are
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	s := &server{upstreams: ups, params: params, forwarder: forwarder, transport: base}
+	if len(params.HealthCheckPath) > 0 {
+		s.healthChecker = newHealthChecker(s, base)
+		s.healthChecker.start()
 	}
-	return &server{dst: dst, params: params, forwarder: forwarder}, nil
+	return s, nil
 }
 
 func validateDestinatin(dst *url.URL) error {
 	switch {
-	case dst == nil:
-	case dst.Scheme != "http" && dst.Scheme != "https":
-	case len(dst.Opaque) > 0:
-	case len(dst.RawQuery) > 0:
-	case len(dst.Fragment) > 0:
+	case dst == nil,
+		dst.Scheme != "http" && dst.Scheme != "https",
+		len(dst.Opaque) > 0,
+		len(dst.RawQuery) > 0,
+		len(dst.Fragment) > 0:
 		return errors.New("hfwd: destination URL format must be 'http[s]://[user:pass@]host[:port][/base/path]'")
 	}
 	return nil
 }
 
+// transport is http.RoundTripper of the HTTP/1.1 or the HTTP/2 to the upstreams
+type transport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
 type server struct {
-	dst       *url.URL
-	params    *config.Parameters
-	forwarder *http.Client
+	upstreams     *upstreams
+	params        *config.Parameters
+	forwarder     *http.Client
+	transport     transport
+	healthChecker *healthChecker
+}
+
+func (s *server) Close() error {
+	if s.healthChecker != nil {
+		s.healthChecker.close()
+	}
+	// the connections of the in-flight requests are not affected
+	s.transport.CloseIdleConnections()
+	return nil
+}
+
+func (s *server) upstreamStatuses() []UpstreamStatus {
+	return s.upstreams.statuses()
 }
 
 func (s *server) ServeHTTP(w http.ResponseWriter, orig *http.Request) {
-	req, err := http.NewRequest(orig.Method, orig.URL.String(), orig.Body)
-	if err != nil {
-		log.Printf("hfwd: failed to create a new request: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	// the context of the orig is cancelled when the client goes away
+	ctx := orig.Context()
+	if s.params.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.params.RequestTimeout)
+		defer cancel()
+	}
+	if s.serveCORS(w, orig) {
+		return
+	}
+	if isUpgradeRequest(orig) {
+		s.serveUpgrade(ctx, w, orig)
 		return
 	}
-	s.copyHeader(orig, req)
-	s.rewriteHeader(req)
-	s.rewriteURL(req.URL)
 
-	res, err := s.forwarder.Do(req)
-	if err != nil {
-		log.Printf("hfwd: an error occurrd while forwarding the request: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	tmpl := newHeaderTemplate(orig)
+	rt := newRetrier(&s.params.Retry)
+	var body io.Reader = orig.Body
+	var rb *requestBody
+	switch {
+	case rt.retryable(orig.Method):
+		var err error
+		if rb, err = newRequestBody(orig, s.params.BodyLimit); err != nil {
+			log.Printf("hfwd: failed to read the request body: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	case rt.enabled():
+		// the body is not buffered for the request never retried
+		rb = &requestBody{orig: orig.Body}
+	}
+
+	for attempt := 1; ; attempt++ {
+		if rb != nil {
+			body = rb.reader()
+		}
+		req, err := http.NewRequest(orig.Method, orig.URL.String(), body)
+		if err != nil {
+			log.Printf("hfwd: failed to create a new request: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		req = relayInformational(w, req.WithContext(ctx))
+		s.copyHeader(orig, req)
+		s.setForwardedHeaders(orig, req)
+		if len(orig.Trailer) > 0 {
+			// the values of the orig.Trailer are filled when the body is read to EOF.
+			// the trailers are sent only in the chunked encoding
+			req.Trailer = orig.Trailer
+			req.ContentLength = -1
+		}
+		s.rewriteHeader(req, tmpl)
+		s.setClientCertHeaders(orig, req)
+
+		ups := s.upstreams.next()
+		if ups == nil {
+			log.Printf("hfwd: no available upstream for the request %v", orig.URL)
+			writeError(w, &s.params.ErrorResponse, &gatewayError{
+				Status:  http.StatusServiceUnavailable,
+				Stage:   stageUpstream,
+				Message: "no available upstream",
+			})
+			return
+		}
+		ups.acquire()
+		s.rewriteURL(req.URL, ups.url)
+
+		res, err := s.forwarder.Do(req)
+		if err != nil {
+			log.Printf("hfwd: an error occurrd while forwarding the request: %v", err)
+			if isUpstreamFailure(ctx, err) {
+				s.upstreams.fail(ups, err)
+			}
+		} else {
+			s.upstreams.succeed(ups)
+		}
+
+		if rt.enabled() {
+			if retry, wait := rt.shouldRetry(orig, rb, attempt, res, err); retry {
+				if res != nil {
+					io.Copy(ioutil.Discard, res.Body)
+					res.Body.Close()
+				}
+				ups.release()
+				log.Printf("hfwd: retry the request %v after %v (attempt %d)", orig.URL, wait, attempt)
+				select {
+				case <-time.After(wait):
+					continue
+				case <-ctx.Done():
+					if orig.Context().Err() == nil {
+						writeError(w, &s.params.ErrorResponse, newGatewayError(ups.url, ctx.Err(), s.params.Verbose))
+					}
+					return
+				}
+			}
+			w.Header().Set(attemptsHeader, strconv.Itoa(attempt))
+		}
+
+		if err != nil {
+			writeError(w, &s.params.ErrorResponse, newGatewayError(ups.url, err, s.params.Verbose))
+		} else {
+			s.reverseRewrite(orig, res, ups.url)
+			s.rewriteBody(orig, res)
+			s.removeCORSHeaders(res)
+			s.rewriteResponseHeader(res, tmpl)
+			s.writeResponse(w, res)
+		}
+		ups.release()
 		return
 	}
+}
+
+// writeResponse writes the response from the upstream
+func (s *server) writeResponse(w http.ResponseWriter, res *http.Response) {
 	defer res.Body.Close()
 
+	removeHopByHopHeaders(res.Header)
 	for h, vv := range res.Header {
 		for _, v := range vv {
 			w.Header().Add(h, v)
 		}
 	}
+	// the trailers are announced before the body, and the values are filled when the body is read
+	announced := len(res.Trailer)
+	if announced > 0 {
+		names := make([]string, 0, announced)
+		for name := range res.Trailer {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.Header().Add("Trailer", strings.Join(names, ", "))
+	}
 	w.WriteHeader(res.StatusCode)
-	io.Copy(w, res.Body)
+	copyResponseBody(w, res.Body, s.flushInterval(res))
+
+	// closes the body to populate the res.Trailer
+	res.Body.Close()
+	for name, vv := range res.Trailer {
+		if announced != len(res.Trailer) {
+			// the trailers which are not announced
+			name = http.TrailerPrefix + name
+		}
+		w.Header()[name] = vv
+	}
 }
 
 func (s *server) copyHeader(orig, req *http.Request) {
 	req.Header = make(http.Header)
 	for k, v := range orig.Header {
-		if _, ok := hopByHopHeaders[k]; ok {
-			continue
-		}
 		req.Header[k] = v
 	}
+	removeHopByHopHeaders(req.Header)
+	// "TE: trailers" tells that the client accepts the trailers. gRPC requires it
+	if headerHasToken(orig.Header, "Te", "trailers") {
+		req.Header.Set("Te", "trailers")
+	}
 }
 
-func (s *server) rewriteHeader(req *http.Request) {
-	for k := range s.params.Header {
+// rewriteHeader sets the headers of the params to the req, and then rewrites the headers by the rules.
+// The placeholders in the values are expanded by the tmpl.
+func (s *server) rewriteHeader(req *http.Request, tmpl *headerTemplate) {
+	for k, vv := range s.params.Header {
 		if k == "Host" {
-			req.Host = s.params.Header.Get(k)
+			req.Host = tmpl.expand(s.params.Header.Get(k))
 			continue
 		}
-		req.Header[k] = s.params.Header[k]
+		expanded := make([]string, len(vv))
+		for i, v := range vv {
+			expanded[i] = tmpl.expand(v)
+		}
+		req.Header[k] = expanded
+	}
+	for i := range s.params.RequestHeaderRules {
+		s.params.RequestHeaderRules[i].Do(req.Header, tmpl.expand)
+	}
+}
+
+// rewriteResponseHeader rewrites the headers of the res from the upstream by the rules which match to the res
+func (s *server) rewriteResponseHeader(res *http.Response, tmpl *headerTemplate) {
+	for i := range s.params.ResponseHeaderRules {
+		rule := &s.params.ResponseHeaderRules[i]
+		if rule.Match(res.StatusCode, res.Header.Get("Content-Type")) {
+			rule.Do(res.Header, tmpl.expand)
+		}
 	}
 }
 
-func (s *server) rewriteURL(reqURL *url.URL) {
+func (s *server) rewriteURL(reqURL, dst *url.URL) {
 	for _, rewrite := range s.params.PathRewriters() {
 		if ok := rewrite.Do(reqURL); ok {
 			break
 		}
 	}
 
-	dstURL := *s.dst
+	dstURL := *dst
 	if dstURL.User == nil {
 		dstURL.User = reqURL.User
 	}
@@ -132,17 +366,48 @@ func (s *server) rewriteURL(reqURL *url.URL) {
 // transport-level connection, and are not stored by caches or
 // forwarded by proxies.
 //
-// https://tools.ietf.org/html/rfc2616#section-13.5.1
+// https://tools.ietf.org/html/rfc7230#section-6.1
 var hopByHopHeaders = map[string]struct{}{
 	// Header names are canonicalized (see http.Request or http.Response).
 	"Connection":          {},
+	"Proxy-Connection":    {}, // non-standard, but sent by some old clients
 	"Keep-Alive":          {},
 	"Proxy-Authenticate":  {},
 	"Proxy-Authorization": {},
-	"TE":                {},
-	"Trailers":          {},
-	"Transfer-Encoding": {},
-	"Upgrade":           {},
+	"Te":                  {},
+	"Trailer":             {}, // the trailers are announced again by the writeResponse
+	"Transfer-Encoding":   {},
+	"Upgrade":             {},
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers and the headers listed in the Connection header from the h
+func removeHopByHopHeaders(h http.Header) {
+	for _, v := range h["Connection"] {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// verboseDumpBodyLimit is the max size in bytes of the request body dumped in the verbose output
+const verboseDumpBodyLimit = 64 << 10
+
+// isDumpableBody reports whether the body of the req can be buffered for the dump.
+// The bodies of the unknown length, over the verboseDumpBodyLimit or waiting for the 100 Continue are streamed without the dump.
+func isDumpableBody(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody {
+		return true
+	}
+	// the ContentLength 0 with the body means the unknown length
+	if req.ContentLength <= 0 || req.ContentLength > verboseDumpBodyLimit {
+		return false
+	}
+	return !strings.EqualFold(req.Header.Get("Expect"), "100-continue")
 }
 
 type verboseRoundTripper struct {
@@ -164,8 +429,8 @@ func (rt *verboseRoundTripper) RoundTrip(req *http.Request) (res *http.Response,
 		log.Print(b.String())
 	}()
 
-	// dump request
-	reqDump, err = httputil.DumpRequest(req, true)
+	// dump request. the streaming bodies are not buffered, and only the headers are dumped like the response
+	reqDump, err = httputil.DumpRequest(req, isDumpableBody(req))
 	if err != nil {
 		return nil, fmt.Errorf("hfwd: failed to dump the request: %v", err)
 	}
@@ -194,18 +459,9 @@ func (rt *verboseRoundTripper) RoundTrip(req *http.Request) (res *http.Response,
 		res.Body = ioutil.NopCloser(bytes.NewReader(raw))
 		res.ContentLength = cl
 
-		rd := ioutil.NopCloser(bytes.NewReader(raw))
-		switch res.Header.Get("Content-Encoding") {
-		case "gzip":
-			if rd, err = gzip.NewReader(rd); err != nil {
-				return nil, fmt.Errorf("hfwd: failed to create gzip reader for read the response body: %v", err)
-			}
-		case "deflate":
-			rd = flate.NewReader(rd)
-		case "br":
-			if rd, err = brotli.NewReader(rd, nil); err != nil {
-				return nil, fmt.Errorf("hfwd: failed to create brotli reader for read the response body: %v", err)
-			}
+		rd, err := decodingReader(strings.ToLower(res.Header.Get("Content-Encoding")), raw)
+		if err != nil {
+			return nil, fmt.Errorf("hfwd: failed to create the decoding reader for read the response body: %v", err)
 		}
 
 		resDumpBody, err = ioutil.ReadAll(rd)
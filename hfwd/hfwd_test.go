@@ -1,9 +1,13 @@
 package hfwd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"encoding/json"
 	"io/ioutil"
@@ -71,6 +75,28 @@ func configParam(subconfig ...interface{}) *config.Parameters {
 			c.Headers = sc
 		case config.TLSClient:
 			c.TLSClient = sc
+		case config.Upstream:
+			c.Upstream = sc
+		case config.Retry:
+			c.Retry = sc
+		case config.ErrorResponse:
+			c.ErrorResponse = sc
+		case config.Timeouts:
+			c.Timeouts = sc
+		case config.ClientCertHeaders:
+			c.ClientCertHeaders = sc
+		case config.Streaming:
+			c.Streaming = sc
+		case config.ForwardedHeaders:
+			c.ForwardedHeaders = sc
+		case config.ReverseRewrite:
+			c.ReverseRewrite = sc
+		case config.ResponseBody:
+			c.ResponseBody = sc
+		case config.ResponseHeaders:
+			c.ResponseHeaders = sc
+		case config.CORS:
+			c.CORS = sc
 		}
 	}
 
@@ -127,6 +153,137 @@ func TestServer_ServeHTTP(t *testing.T) {
 		})
 	})
 
+	t.Run("request header rules", func(t *testing.T) {
+		dstServer := httptest.NewServer(dstMux)
+		defer dstServer.Close()
+		rwHeader := make(http.Header)
+		rwHeader.Set("X-Client", "{client_ip}")
+		rwHeader.Set("X-Literal", "{{client_ip}")
+		params := configParam(config.Headers{Header: rwHeader, RequestHeaderRules: []config.HeaderRule{
+			{Action: config.HeaderActionDelete, Name: "Cookie"},
+			{Action: config.HeaderActionReplace, Name: "Origin", Pattern: "^https?://[^/]+$", Value: "https://{host}"},
+			{Action: config.HeaderActionAdd, Name: "X-Original-Host", Value: "{host}"},
+		}})
+
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			req, _ := http.NewRequest("GET", proxyURL+"/dumpHeaders", nil)
+			req.Header.Set("Cookie", "sid=1")
+			req.Header.Set("Origin", "http://localhost:3000")
+			res, err := http.DefaultClient.Do(req)
+			assertOKResponse(t, res, err)
+
+			defer res.Body.Close()
+			b, _ := ioutil.ReadAll(res.Body)
+			dumpHeaders := make(http.Header)
+			json.Unmarshal(b, &dumpHeaders)
+
+			host := mustURL(proxyURL).Host
+			want := map[string]string{
+				"Cookie":          "",
+				"Origin":          "https://" + host,
+				"X-Original-Host": host,
+				"X-Client":        "127.0.0.1",
+				"X-Literal":       "{client_ip}",
+			}
+			for name, w := range want {
+				if g := dumpHeaders.Get(name); g != w {
+					t.Errorf("request header %v got %v, want %v", name, g, w)
+				}
+			}
+		})
+	})
+
+	t.Run("remove hop-by-hop headers", func(t *testing.T) {
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := json.Marshal(r.Header)
+			w.Header().Set("Connection", "X-Response-Hop")
+			w.Header().Set("X-Response-Hop", "hop")
+			w.Header().Set("Keep-Alive", "timeout=5")
+			w.Header().Set("X-Response-End", "end")
+			w.Write(b)
+		}))
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			req, _ := http.NewRequest("GET", proxyURL, nil)
+			req.Header.Set("Connection", "keep-alive, X-Request-Hop")
+			req.Header.Set("X-Request-Hop", "hop")
+			req.Header.Set("Proxy-Connection", "keep-alive")
+			req.Header.Set("Te", "trailers, deflate")
+			req.Header.Set("X-Request-End", "end")
+			res, err := http.DefaultClient.Do(req)
+			assertOKResponse(t, res, err)
+
+			defer res.Body.Close()
+			b, _ := ioutil.ReadAll(res.Body)
+			dumpHeaders := make(http.Header)
+			json.Unmarshal(b, &dumpHeaders)
+
+			for _, name := range []string{"X-Request-Hop", "Proxy-Connection"} {
+				if g := dumpHeaders.Get(name); g != "" {
+					t.Errorf("request header %v got %v, want blank", name, g)
+				}
+			}
+			if g, w := dumpHeaders.Get("Te"), "trailers"; g != w {
+				t.Errorf("request header Te got %v, want %v", g, w)
+			}
+			if g, w := dumpHeaders.Get("X-Request-End"), "end"; g != w {
+				t.Errorf("request header X-Request-End got %v, want %v", g, w)
+			}
+			for _, name := range []string{"X-Response-Hop", "Keep-Alive"} {
+				if g := res.Header.Get(name); g != "" {
+					t.Errorf("response header %v got %v, want blank", name, g)
+				}
+			}
+			if g, w := res.Header.Get("X-Response-End"), "end"; g != w {
+				t.Errorf("response header X-Response-End got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("rewriteResponseHeader", func(t *testing.T) {
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Server", "nginx/1.15")
+			w.Header().Set("Strict-Transport-Security", "max-age=31536000")
+			w.Header().Set("Content-Type", "text/html")
+			if r.URL.Path == "/missing" {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer dstServer.Close()
+
+		params := configParam(config.ResponseHeaders{ResponseHeaderRules: []config.HeaderRule{
+			{Action: config.HeaderActionDelete, Name: "Strict-Transport-Security"},
+			{Action: config.HeaderActionReplace, Name: "Server", Pattern: "/.*$"},
+			{Action: config.HeaderActionSet, Name: "Cache-Control", Value: "no-store", StatusCodes: []int{404}, ContentTypes: []string{"text/html"}},
+		}})
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			tt := []struct {
+				path         string
+				cacheControl string
+			}{
+				{path: "/", cacheControl: ""},
+				{path: "/missing", cacheControl: "no-store"},
+			}
+			for _, te := range tt {
+				res, err := http.Get(proxyURL + te.path)
+				if err != nil {
+					t.Fatalf("failed to GET: %v", err)
+				}
+				res.Body.Close()
+				if g := res.Header.Get("Strict-Transport-Security"); g != "" {
+					t.Errorf("%v: Strict-Transport-Security got %v, want blank", te.path, g)
+				}
+				if g, w := res.Header.Get("Server"), "nginx"; g != w {
+					t.Errorf("%v: Server got %v, want %v", te.path, g, w)
+				}
+				if g, w := res.Header.Get("Cache-Control"), te.cacheControl; g != w {
+					t.Errorf("%v: Cache-Control got %v, want %v", te.path, g, w)
+				}
+			}
+		})
+	})
+
 	t.Run("rewriteURL", func(t *testing.T) {
 		dstServer := httptest.NewServer(dstMux)
 		defer dstServer.Close()
@@ -145,6 +302,67 @@ func TestServer_ServeHTTP(t *testing.T) {
 	})
 }
 
+func TestServer_ServeHTTP_Timeouts(t *testing.T) {
+	slow := func(release <-chan struct{}) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+	}
+
+	tt := []struct {
+		name     string
+		timeouts config.Timeouts
+	}{
+		{name: "response header timeout", timeouts: config.Timeouts{ResponseHeaderTimeout: 50 * time.Millisecond}},
+		{name: "request timeout", timeouts: config.Timeouts{RequestTimeout: 50 * time.Millisecond}},
+	}
+	for _, te := range tt {
+		t.Run(te.name, func(t *testing.T) {
+			release := make(chan struct{})
+			dstServer := httptest.NewServer(slow(release))
+			defer dstServer.Close()
+			defer close(release)
+
+			withRunProxy(dstServer.URL, configParam(te.timeouts), func(proxyURL string) {
+				res, err := http.Get(proxyURL)
+				if err != nil {
+					t.Fatalf("failed to GET: %v", err)
+				}
+				defer res.Body.Close()
+				if g, w := res.StatusCode, http.StatusGatewayTimeout; g != w {
+					t.Errorf("res.StatusCode got %v, want %v", g, w)
+				}
+			})
+		})
+	}
+
+	t.Run("client goes away", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+			close(cancelled)
+		}))
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequest("GET", proxyURL, nil)
+			if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+				t.Errorf("want an error, got nil")
+			}
+			select {
+			case <-cancelled:
+			case <-time.After(5 * time.Second):
+				t.Errorf("the request to the destination is not cancelled")
+			}
+		})
+	})
+}
+
 func TestServer_rewriteURL(t *testing.T) {
 	t.Run("rewrite path", func(t *testing.T) {
 		tt := []struct {
@@ -174,14 +392,61 @@ func TestServer_rewriteURL(t *testing.T) {
 		}
 
 		for _, te := range tt {
-			s := &server{dst: te.dst, params: te.params}
+			s := &server{params: te.params}
 			if s.params == nil {
 				s.params = configParam()
 			}
-			s.rewriteURL(te.orig)
+			s.rewriteURL(te.orig, te.dst)
 			if g, w := te.orig.String(), te.want.String(); g != w {
 				t.Errorf("url got %v, want %v", g, w)
 			}
 		}
 	})
 }
+
+func TestValidateDestination(t *testing.T) {
+	tt := []struct {
+		dst     *url.URL
+		wantErr bool
+	}{
+		{dst: mustURL("https://u:p@www.example.com:8443/base"), wantErr: false},
+		{dst: nil, wantErr: true},
+		{dst: mustURL("ftp://www.example.com"), wantErr: true},
+		{dst: mustURL("http:opaque"), wantErr: true},
+		{dst: mustURL("http://www.example.com?q=v"), wantErr: true},
+		{dst: mustURL("http://www.example.com#frag"), wantErr: true},
+	}
+	for _, te := range tt {
+		err := validateDestinatin(te.dst)
+		if g, w := err != nil, te.wantErr; g != w {
+			t.Errorf("%v: err got %v, want err %v", te.dst, err, w)
+		}
+	}
+}
+
+func TestIsDumpableBody(t *testing.T) {
+	newRequest := func(body io.Reader, contentLength int64, expect string) *http.Request {
+		req := httptest.NewRequest("POST", "http://www.example.com/", body)
+		req.ContentLength = contentLength
+		if expect != "" {
+			req.Header.Set("Expect", expect)
+		}
+		return req
+	}
+	tt := []struct {
+		req  *http.Request
+		want bool
+	}{
+		{req: httptest.NewRequest("GET", "http://www.example.com/", nil), want: true},
+		{req: newRequest(strings.NewReader("body"), 4, ""), want: true},
+		{req: newRequest(strings.NewReader("body"), -1, ""), want: false},
+		{req: newRequest(strings.NewReader("body"), 0, ""), want: false},
+		{req: newRequest(strings.NewReader("body"), verboseDumpBodyLimit+1, ""), want: false},
+		{req: newRequest(strings.NewReader("body"), 4, "100-continue"), want: false},
+	}
+	for i, te := range tt {
+		if g, w := isDumpableBody(te.req), te.want; g != w {
+			t.Errorf("%v: isDumpableBody got %v, want %v", i, g, w)
+		}
+	}
+}
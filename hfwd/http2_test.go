@@ -0,0 +1,113 @@
+package hfwd
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cClient is http.Client which speaks the h2c with prior knowledge
+var h2cClient = &http.Client{
+	Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	},
+}
+
+// echoStreamHandler echoes back the lines of the request body as soon as read, like the gRPC bidirectional streaming
+var echoStreamHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("X-Proto", r.Proto)
+	w.WriteHeader(200)
+	w.(http.Flusher).Flush()
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			break
+		}
+		io.WriteString(w, line)
+		w.(http.Flusher).Flush()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+})
+
+func TestServer_ServeHTTP_HTTP2(t *testing.T) {
+	t.Run("https upstream", func(t *testing.T) {
+		dstServer := httptest.NewUnstartedServer(echoStreamHandler)
+		dstServer.TLS = &tls.Config{NextProtos: []string{"h2"}}
+		if err := http2.ConfigureServer(dstServer.Config, nil); err != nil {
+			t.Fatalf("failed to configure HTTP/2: %v", err)
+		}
+		dstServer.StartTLS()
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(config.TLSClient{Insecure: true}), func(proxyURL string) {
+			res, err := http.Get(proxyURL)
+			assertOKResponse(t, res, err)
+			defer res.Body.Close()
+			if g, w := res.Header.Get("X-Proto"), "HTTP/2.0"; g != w {
+				t.Errorf("X-Proto got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("h2c full duplex", func(t *testing.T) {
+		dstServer := httptest.NewServer(h2c.NewHandler(echoStreamHandler, &http2.Server{}))
+		defer dstServer.Close()
+
+		h, err := NewHandler(mustURL(dstServer.URL), configParam(config.Upstream{H2C: true}))
+		if err != nil {
+			t.Fatalf("failed to create the handler: %v", err)
+		}
+		defer h.Close()
+		proxyServer := httptest.NewServer(h2c.NewHandler(h, &http2.Server{}))
+		defer proxyServer.Close()
+
+		pr, pw := io.Pipe()
+		req, _ := http.NewRequest("POST", proxyServer.URL, pr)
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+		res, err := h2cClient.Do(req)
+		assertOKResponse(t, res, err)
+		defer res.Body.Close()
+		if g, w := res.Header.Get("X-Proto"), "HTTP/2.0"; g != w {
+			t.Errorf("X-Proto got %v, want %v", g, w)
+		}
+
+		// the response of each message arrives before the request body ends
+		br := bufio.NewReader(res.Body)
+		for _, msg := range []string{"ping\n", "pong\n"} {
+			io.WriteString(pw, msg)
+			line, err := br.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+			if g, w := line, msg; g != w {
+				t.Errorf("echo got %q, want %q", g, w)
+			}
+		}
+		pw.Close()
+		io.Copy(ioutil.Discard, br)
+		if g, w := res.Trailer.Get("Grpc-Status"), "0"; g != w {
+			t.Errorf("Grpc-Status got %v, want %v", g, w)
+		}
+	})
+
+	t.Run("h2c requires http", func(t *testing.T) {
+		if _, err := NewHandler(mustURL("https://example.com"), configParam(config.Upstream{H2C: true})); err == nil {
+			t.Errorf("want an error, got nil")
+		}
+	})
+}
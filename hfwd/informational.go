@@ -0,0 +1,47 @@
+package hfwd
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"time"
+)
+
+// expectContinueTimeout is the max duration of waiting for the 100 Continue from the upstream.
+// The request body is sent after the timeout even if the upstream does not respond.
+const expectContinueTimeout = 1 * time.Second
+
+// relayInformational returns the req which relays the 1xx informational responses from the upstream such as 103 Early Hints to the w.
+// 100 Continue is not relayed, since the http.Server sends it when the request body is read.
+func relayInformational(w http.ResponseWriter, req *http.Request) *http.Request {
+	if !canWriteInformational {
+		return req
+	}
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusContinue {
+				return nil
+			}
+			// the 1xx is written with its own headers only, and then the headers of the final response
+			// which are already set such as the CORS are restored
+			h := w.Header()
+			final := make(http.Header, len(h))
+			for k, vv := range h {
+				final[k] = vv
+				delete(h, k)
+			}
+			for k, vv := range header {
+				h[k] = vv
+			}
+			w.WriteHeader(code)
+			for k := range h {
+				delete(h, k)
+			}
+			for k, vv := range final {
+				h[k] = vv
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
@@ -0,0 +1,8 @@
+//go:build go1.19
+// +build go1.19
+
+package hfwd
+
+// canWriteInformational reports whether http.ResponseWriter can write the 1xx informational responses.
+// WriteHeader accepts the 1xx status codes since Go 1.19.
+const canWriteInformational = true
@@ -0,0 +1,8 @@
+//go:build !go1.19
+// +build !go1.19
+
+package hfwd
+
+// canWriteInformational reports whether http.ResponseWriter can write the 1xx informational responses.
+// WriteHeader before Go 1.19 treats the 1xx status codes as the final response.
+const canWriteInformational = false
@@ -0,0 +1,192 @@
+package hfwd
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_ServeHTTP_Trailer(t *testing.T) {
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Trailer", "X-Checksum")
+		w.Header().Set("X-Request-Trailer", r.Trailer.Get("X-Request-Checksum"))
+		w.WriteHeader(200)
+		w.Write(b)
+		w.Header().Set("X-Checksum", "announced")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "unannounced")
+	}))
+	defer dstServer.Close()
+
+	withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+		trailer := http.Header{"X-Request-Checksum": nil}
+		body := &trailerSettingReader{r: strings.NewReader("body"), set: func() { trailer.Set("X-Request-Checksum", "request") }}
+		req, _ := http.NewRequest("POST", proxyURL, body)
+		req.Trailer = trailer
+		res, err := http.DefaultClient.Do(req)
+		assertOKResponse(t, res, err)
+		defer res.Body.Close()
+		if g, w := res.Header.Get("X-Request-Trailer"), "request"; g != w {
+			t.Errorf("request trailer got %v, want %v", g, w)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		if g, w := string(b), "body"; g != w {
+			t.Errorf("body got %v, want %v", g, w)
+		}
+		if g, w := res.Trailer.Get("X-Checksum"), "announced"; g != w {
+			t.Errorf("X-Checksum got %v, want %v", g, w)
+		}
+		if g, w := res.Trailer.Get("X-Late"), "unannounced"; g != w {
+			t.Errorf("X-Late got %v, want %v", g, w)
+		}
+	})
+}
+
+// trailerSettingReader calls the set on EOF to fill the request trailers
+type trailerSettingReader struct {
+	r   io.Reader
+	set func()
+}
+
+func (tr *trailerSettingReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	if err == io.EOF {
+		tr.set()
+	}
+	return n, err
+}
+
+func TestServer_ServeHTTP_Informational(t *testing.T) {
+	const statusEarlyHints = 103
+
+	t.Run("early hints", func(t *testing.T) {
+		if !canWriteInformational {
+			t.Skip("the 1xx can not be written by this Go version")
+		}
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			w.WriteHeader(statusEarlyHints)
+			w.Header().Del("Link")
+			w.WriteHeader(200)
+		}))
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			var hints []string
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == statusEarlyHints {
+						hints = append(hints, header.Get("Link"))
+					}
+					return nil
+				},
+			}
+			req, _ := http.NewRequest("GET", proxyURL, nil)
+			res, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			assertOKResponse(t, res, err)
+			defer res.Body.Close()
+			if g, w := len(hints), 1; g != w {
+				t.Fatalf("len(hints) got %v, want %v", g, w)
+			}
+			if g, w := hints[0], "</style.css>; rel=preload; as=style"; g != w {
+				t.Errorf("Link got %v, want %v", g, w)
+			}
+			if g := res.Header.Get("Link"); g != "" {
+				t.Errorf("Link of the final response got %v, want blank", g)
+			}
+		})
+	})
+
+	t.Run("early hints keep the headers of the final response", func(t *testing.T) {
+		if !canWriteInformational {
+			t.Skip("the 1xx can not be written by this Go version")
+		}
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Link", "</style.css>; rel=preload; as=style")
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.WriteHeader(statusEarlyHints)
+			w.Header().Del("Link")
+			w.WriteHeader(200)
+		}))
+		defer dstServer.Close()
+
+		params := configParam(config.CORS{EnableCORS: true, CORSOrigins: []string{"http://localhost:3000"}})
+		withRunProxy(dstServer.URL, params, func(proxyURL string) {
+			var hints []textproto.MIMEHeader
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					if code == statusEarlyHints {
+						hints = append(hints, header)
+					}
+					return nil
+				},
+			}
+			req, _ := http.NewRequest("GET", proxyURL, nil)
+			req.Header.Set("Origin", "http://localhost:3000")
+			res, err := http.DefaultClient.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			assertOKResponse(t, res, err)
+			defer res.Body.Close()
+			if g, w := len(hints), 1; g != w {
+				t.Fatalf("len(hints) got %v, want %v", g, w)
+			}
+			if g, w := hints[0].Get("Access-Control-Allow-Origin"), "*"; g != w {
+				t.Errorf("Access-Control-Allow-Origin of the early hints got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Access-Control-Allow-Origin"), "http://localhost:3000"; g != w {
+				t.Errorf("Access-Control-Allow-Origin got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Vary"), "Origin"; g != w {
+				t.Errorf("Vary got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("expect continue rejected", func(t *testing.T) {
+		dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}))
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			var read int32
+			req, _ := http.NewRequest("POST", proxyURL, readNotifier{r: strings.NewReader("body"), read: &read})
+			req.ContentLength = 4
+			req.Header.Set("Expect", "100-continue")
+			client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			res, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				t.Fatalf("failed to POST: %v", err)
+			}
+			defer res.Body.Close()
+			if g, w := res.StatusCode, http.StatusUnauthorized; g != w {
+				t.Errorf("res.StatusCode got %v, want %v", g, w)
+			}
+			if atomic.LoadInt32(&read) != 0 {
+				t.Errorf("the request body is sent before the 100 Continue")
+			}
+		})
+	})
+}
+
+// readNotifier records the read of the r
+type readNotifier struct {
+	r    io.Reader
+	read *int32
+}
+
+func (rn readNotifier) Read(p []byte) (int, error) {
+	atomic.StoreInt32(rn.read, 1)
+	return rn.r.Read(p)
+}
@@ -0,0 +1,172 @@
+package hfwd
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dsnet/compress/brotli"
+)
+
+// rewriteBody rewrites the response body by the substitution rules, and fixes up the Content-Length.
+// The body is streamed unmodified when it exceeds the RewriteBodyLimit or can not be decoded.
+// The brotli can not be encoded again, so that the rewritten body of the brotli is sent without the encoding.
+func (s *server) rewriteBody(orig *http.Request, res *http.Response) {
+	params := &s.params.ResponseBody
+	rules := params.BodyRules()
+	switch {
+	case len(rules) == 0,
+		orig.Method == http.MethodHead,
+		res.StatusCode < 200,
+		res.StatusCode == http.StatusNoContent,
+		res.StatusCode == http.StatusPartialContent,
+		res.StatusCode == http.StatusNotModified,
+		!params.IsRewriteBodyType(res.Header.Get("Content-Type")):
+		return
+	}
+	encoding := strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding")))
+	if !canDecodeBody(encoding) {
+		if s.params.Verbose {
+			log.Printf("hfwd does not rewrite the response body of the Content-Encoding %q", encoding)
+		}
+		return
+	}
+	limit := params.RewriteBodyLimit
+	if res.ContentLength > limit {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil || int64(len(raw)) > limit {
+		// the rest of the body, or the same error, follows the bytes already read
+		res.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(raw), res.Body), Closer: res.Body}
+		return
+	}
+	res.Body.Close()
+	res.Body = ioutil.NopCloser(bytes.NewReader(raw))
+
+	body, err := decodeBody(encoding, raw, limit)
+	if err != nil {
+		log.Printf("hfwd: failed to decode the response body to rewrite: %v", err)
+		return
+	}
+	scheme := "http"
+	if orig.TLS != nil {
+		scheme = "https"
+	}
+	listener := scheme + "://" + orig.Host
+	decoded := body
+	for _, rule := range rules {
+		body = rule.Do(body, listener)
+	}
+	if bytes.Equal(body, decoded) {
+		// the original body and the Etag are kept as they are
+		return
+	}
+	if encoding == "br" {
+		encoding = ""
+		res.Header.Del("Content-Encoding")
+	}
+	if body, err = encodeBody(encoding, raw, body); err != nil {
+		log.Printf("hfwd: failed to encode the rewritten response body: %v", err)
+		return
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// the rewritten body is no longer byte-for-byte identical
+	if etag := res.Header.Get("Etag"); strings.HasPrefix(etag, `"`) {
+		res.Header.Set("Etag", "W/"+etag)
+	}
+}
+
+// readCloser is io.ReadCloser of the Reader and the Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// canDecodeBody reports whether the body of the Content-Encoding can be decoded
+func canDecodeBody(encoding string) bool {
+	switch encoding {
+	case "", "identity", "gzip", "deflate", "br":
+		return true
+	}
+	return false
+}
+
+// decodingReader returns the reader which decodes the raw body of the Content-Encoding.
+// The "deflate" is the zlib format, but the raw deflate which is sent by some servers is also accepted.
+func decodingReader(encoding string, raw []byte) (io.Reader, error) {
+	switch encoding {
+	case "", "identity":
+		return bytes.NewReader(raw), nil
+	case "gzip":
+		return gzip.NewReader(bytes.NewReader(raw))
+	case "deflate":
+		if isZlib(raw) {
+			return zlib.NewReader(bytes.NewReader(raw))
+		}
+		return flate.NewReader(bytes.NewReader(raw)), nil
+	case "br":
+		return brotli.NewReader(bytes.NewReader(raw), nil)
+	}
+	return nil, fmt.Errorf("hfwd: unsupported Content-Encoding %q", encoding)
+}
+
+// decodeBody decodes the raw body of the Content-Encoding. The decoded body must not exceed the limit.
+func decodeBody(encoding string, raw []byte, limit int64) ([]byte, error) {
+	rd, err := decodingReader(encoding, raw)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(rd, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, fmt.Errorf("hfwd: the decoded body exceeds the limit %d", limit)
+	}
+	return body, nil
+}
+
+// encodeBody encodes the body of the Content-Encoding in the same format as the raw
+func encodeBody(encoding string, raw, body []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "", "identity":
+		return body, nil
+	case "gzip":
+		w = gzip.NewWriter(&b)
+	case "deflate":
+		if isZlib(raw) {
+			w = zlib.NewWriter(&b)
+		} else {
+			w, _ = flate.NewWriter(&b, flate.DefaultCompression)
+		}
+	default:
+		return nil, fmt.Errorf("hfwd: unsupported Content-Encoding %q", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// isZlib reports whether the b starts with the zlib header (RFC 1950)
+func isZlib(b []byte) bool {
+	return len(b) >= 2 && b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
@@ -0,0 +1,169 @@
+package hfwd
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_rewriteBody(t *testing.T) {
+	var dstURL string
+	encoders := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw-deflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `<a href="` + dstURL + `/login">login</a>`
+		switch r.URL.Path {
+		case "/html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Etag", `"abc"`)
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			body += strings.Repeat(" ", 1024)
+		case "/unchanged":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Etag", `"abc"`)
+			body = "no rules match"
+		case "/br":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "br")
+			body = string(brotliStored([]byte(body)))
+		case "/accept-encoding":
+			w.Header().Set("Content-Type", "text/plain")
+			body = r.Header.Get("Accept-Encoding")
+		default:
+			enc := strings.TrimPrefix(r.URL.Path, "/")
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", strings.TrimPrefix(enc, "raw-"))
+			var b bytes.Buffer
+			ew := encoders[enc](&b)
+			io.WriteString(ew, body)
+			ew.Close()
+			body = b.String()
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}))
+	defer dstServer.Close()
+	dstURL = dstServer.URL
+
+	params := configParam(config.ResponseBody{
+		ReplaceBody:      map[string]string{dstURL: config.ListenerPlaceholder},
+		RewriteBody:      map[string]string{`>(\w+)<`: ">[$1]<"},
+		RewriteBodyLimit: 1024,
+	})
+	withRunProxy(dstURL, params, func(proxyURL string) {
+		get := func(t *testing.T, path, acceptEncoding string) (*http.Response, []byte) {
+			t.Helper()
+			req, _ := http.NewRequest("GET", proxyURL+path, nil)
+			if acceptEncoding != "" {
+				// disables the transparent decompression of the http.Transport
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to GET: %v", err)
+			}
+			defer res.Body.Close()
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read the body: %v", err)
+			}
+			if g, w := res.ContentLength, int64(len(b)); g != w {
+				t.Errorf("res.ContentLength got %v, want %v", g, w)
+			}
+			return res, b
+		}
+		rewritten := `<a href="` + proxyURL + `/login">[login]</a>`
+		original := `<a href="` + dstURL + `/login">login</a>`
+
+		t.Run("html", func(t *testing.T) {
+			res, b := get(t, "/html", "")
+			if g, w := string(b), rewritten; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Etag"), `W/"abc"`; g != w {
+				t.Errorf("Etag got %v, want %v", g, w)
+			}
+		})
+		for _, enc := range []string{"gzip", "deflate", "raw-deflate"} {
+			t.Run(enc, func(t *testing.T) {
+				res, b := get(t, "/"+enc, "gzip, deflate")
+				if g, w := res.Header.Get("Content-Encoding"), strings.TrimPrefix(enc, "raw-"); g != w {
+					t.Errorf("Content-Encoding got %v, want %v", g, w)
+				}
+				rd, err := decodingReader(res.Header.Get("Content-Encoding"), b)
+				if err != nil {
+					t.Fatalf("failed to decode: %v", err)
+				}
+				decoded, _ := ioutil.ReadAll(rd)
+				if g, w := string(decoded), rewritten; g != w {
+					t.Errorf("body got %v, want %v", g, w)
+				}
+			})
+		}
+		t.Run("unchanged", func(t *testing.T) {
+			res, b := get(t, "/unchanged", "")
+			if g, w := string(b), "no rules match"; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get("Etag"), `"abc"`; g != w {
+				t.Errorf("Etag got %v, want %v", g, w)
+			}
+		})
+		t.Run("br", func(t *testing.T) {
+			res, b := get(t, "/br", "br")
+			if g := res.Header.Get("Content-Encoding"); g != "" {
+				t.Errorf("Content-Encoding got %v, want blank", g)
+			}
+			if g, w := string(b), rewritten; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+		})
+		t.Run("other types", func(t *testing.T) {
+			_, b := get(t, "/png", "")
+			if g, w := string(b), original; g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+		})
+		t.Run("exceeds the limit", func(t *testing.T) {
+			_, b := get(t, "/large", "")
+			if g, w := string(b), original+strings.Repeat(" ", 1024); g != w {
+				t.Errorf("body got %v, want %v", g, w)
+			}
+		})
+		t.Run("brotli is requested", func(t *testing.T) {
+			_, b := get(t, "/accept-encoding", "br, gzip;q=0.8")
+			if g, w := string(b), "br, gzip;q=0.8"; g != w {
+				t.Errorf("Accept-Encoding got %v, want %v", g, w)
+			}
+		})
+	})
+}
+
+// brotliStored encodes the b in the brotli stream of an uncompressed meta-block (RFC 7932), since there is no brotli encoder
+func brotliStored(b []byte) []byte {
+	// WBITS 16, ISLAST 0, MNIBBLES 4, MLEN-1, ISUNCOMPRESSED 1 and the padding to the byte boundary
+	header := uint32(len(b)-1)<<4 | 1<<20
+	stream := []byte{byte(header), byte(header >> 8), byte(header >> 16)}
+	stream = append(stream, b...)
+	// ISLAST 1 and ISLASTEMPTY 1
+	return append(stream, 0x03)
+}
@@ -0,0 +1,130 @@
+package hfwd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// attemptsHeader is the response header which records the count of the attempts
+const attemptsHeader = "X-Hfwd-Attempts"
+
+// requestBody provides the request body for each attempt
+type requestBody struct {
+	orig       io.ReadCloser
+	buf        []byte
+	replayable bool
+}
+
+// newRequestBody buffers the body of the orig up to the limit for the replay.
+// If the body exceeds the limit, is of the unknown length or waits for the 100 Continue,
+// the body is not replayable and streamed to the first attempt only.
+func newRequestBody(orig *http.Request, limit int64) (*requestBody, error) {
+	if orig.Body == nil || orig.Body == http.NoBody || orig.ContentLength == 0 && orig.TransferEncoding == nil {
+		return &requestBody{replayable: true}, nil
+	}
+	if orig.ContentLength < 0 || orig.ContentLength > limit || strings.EqualFold(orig.Header.Get("Expect"), "100-continue") {
+		return &requestBody{orig: orig.Body}, nil
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(orig.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > limit {
+		return &requestBody{orig: orig.Body, buf: buf}, nil
+	}
+	return &requestBody{buf: buf, replayable: true}, nil
+}
+
+// reader returns the request body for the next attempt
+func (b *requestBody) reader() io.Reader {
+	switch {
+	case b.replayable && b.buf == nil:
+		return nil
+	case b.replayable:
+		return bytes.NewReader(b.buf)
+	default:
+		return io.MultiReader(bytes.NewReader(b.buf), b.orig)
+	}
+}
+
+// retrier decides whether to retry the request
+type retrier struct {
+	params *config.Retry
+	start  time.Time
+}
+
+func newRetrier(params *config.Retry) *retrier {
+	return &retrier{params: params, start: time.Now()}
+}
+
+// enabled reports whether the retry is enabled
+func (r *retrier) enabled() bool {
+	return r.params.Retries > 0
+}
+
+// retryable reports whether the requests of the method can be retried
+func (r *retrier) retryable(method string) bool {
+	return r.enabled() && (isIdempotent(method) || r.params.RetryNonIdempotent)
+}
+
+// shouldRetry reports whether to retry the request after the attempt, and the duration to wait before the retry
+func (r *retrier) shouldRetry(orig *http.Request, body *requestBody, attempt int, res *http.Response, err error) (bool, time.Duration) {
+	if attempt > r.params.Retries || !body.replayable || !r.retryable(orig.Method) {
+		return false, 0
+	}
+	switch {
+	case err != nil:
+		if !isIdempotent(orig.Method) {
+			return false, 0
+		}
+	case !r.retryableStatus(res.StatusCode):
+		return false, 0
+	}
+	wait := r.backoff(attempt)
+	if r.params.Budget > 0 && time.Since(r.start)+wait > r.params.Budget {
+		return false, 0
+	}
+	return true, wait
+}
+
+func (r *retrier) retryableStatus(code int) bool {
+	for _, c := range r.params.StatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the exponential backoff with the jitter for the attempt
+func (r *retrier) backoff(attempt int) time.Duration {
+	d := r.params.Backoff
+	for i := 1; i < attempt && d < r.params.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.params.MaxBackoff {
+		d = r.params.MaxBackoff
+	}
+	// equal jitter. wait for [d/2, d)
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
+
+// isIdempotent reports whether the method is idempotent. https://tools.ietf.org/html/rfc7231#section-4.2.2
+func isIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
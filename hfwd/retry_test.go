@@ -0,0 +1,229 @@
+package hfwd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_ServeHTTP_Retry(t *testing.T) {
+	t.Run("retry on the status codes with the replayed body", func(t *testing.T) {
+		var count int32
+		dst := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			if atomic.AddInt32(&count, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write(b)
+		}))
+		defer dst.Close()
+
+		params := configParam(config.Retry{Retries: 2, Backoff: time.Millisecond, StatusCodes: []int{503}, RetryNonIdempotent: true})
+		withRunProxy(dst.URL, params, func(proxyURL string) {
+			res, err := http.Post(proxyURL, "text/plain", strings.NewReader("req body"))
+			assertOKResponse(t, res, err)
+			defer res.Body.Close()
+			b, _ := ioutil.ReadAll(res.Body)
+			if g, w := string(b), "req body"; g != w {
+				t.Errorf("res.Body got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get(attemptsHeader), "3"; g != w {
+				t.Errorf("res.Header[%v] got %v, want %v", attemptsHeader, g, w)
+			}
+		})
+	})
+
+	t.Run("no retry on the status codes of the non-idempotent methods", func(t *testing.T) {
+		var count int32
+		dst := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer dst.Close()
+
+		params := configParam(config.Retry{Retries: 2, Backoff: time.Millisecond, StatusCodes: []int{503}})
+		withRunProxy(dst.URL, params, func(proxyURL string) {
+			res, err := http.Post(proxyURL, "text/plain", strings.NewReader("req body"))
+			if err != nil {
+				t.Fatalf("failed to POST: %v", err)
+			}
+			res.Body.Close()
+			if g, w := atomic.LoadInt32(&count), int32(1); g != w {
+				t.Errorf("count of the requests got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		dst := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer dst.Close()
+
+		params := configParam(config.Retry{Retries: 2, Backoff: time.Millisecond, StatusCodes: []int{502}})
+		withRunProxy(dst.URL, params, func(proxyURL string) {
+			res, err := http.Get(proxyURL)
+			if err != nil {
+				t.Fatalf("failed to GET: %v", err)
+			}
+			res.Body.Close()
+			if g, w := res.StatusCode, http.StatusBadGateway; g != w {
+				t.Errorf("res.StatusCode got %v, want %v", g, w)
+			}
+			if g, w := res.Header.Get(attemptsHeader), "3"; g != w {
+				t.Errorf("res.Header[%v] got %v, want %v", attemptsHeader, g, w)
+			}
+		})
+	})
+
+	t.Run("retry on the transport errors of the idempotent methods", func(t *testing.T) {
+		dst := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		defer dst.Close()
+		closed := httptest.NewServer(http.NotFoundHandler())
+		closed.Close()
+
+		params := configParam(config.Retry{Retries: 1, Backoff: time.Millisecond})
+		for _, te := range []struct {
+			method     string
+			wantStatus int
+		}{
+			{method: "GET", wantStatus: http.StatusOK},
+			{method: "POST", wantStatus: http.StatusBadGateway},
+		} {
+			h, err := NewLoadBalancingHandler([]*url.URL{mustURL(closed.URL), mustURL(dst.URL)}, params)
+			if err != nil {
+				t.Fatalf("failed to create handler: %v", err)
+			}
+			proxyServer := httptest.NewServer(h)
+			req, _ := http.NewRequest(te.method, proxyServer.URL, nil)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("failed to %v: %v", te.method, err)
+			}
+			res.Body.Close()
+			if g, w := res.StatusCode, te.wantStatus; g != w {
+				t.Errorf("%v: res.StatusCode got %v, want %v", te.method, g, w)
+			}
+			proxyServer.Close()
+			h.Close()
+		}
+	})
+}
+
+func TestNewRequestBody(t *testing.T) {
+	tt := []struct {
+		body           string
+		limit          int64
+		unknownLength  bool
+		expect         string
+		wantReplayable bool
+	}{
+		{body: "", limit: 4, wantReplayable: true},
+		{body: "1234", limit: 4, wantReplayable: true},
+		{body: "12345", limit: 4, wantReplayable: false},
+		{body: "1234", limit: 4, unknownLength: true, wantReplayable: false},
+		{body: "1234", limit: 4, expect: "100-continue", wantReplayable: false},
+	}
+	for _, te := range tt {
+		orig := httptest.NewRequest("POST", "http://example.com", strings.NewReader(te.body))
+		if te.unknownLength {
+			orig.ContentLength = -1
+		}
+		if te.expect != "" {
+			orig.Header.Set("Expect", te.expect)
+		}
+		rb, err := newRequestBody(orig, te.limit)
+		if err != nil {
+			t.Fatalf("%v: failed to create request body: %v", te.body, err)
+		}
+		if g, w := rb.replayable, te.wantReplayable; g != w {
+			t.Errorf("%v: replayable got %v, want %v", te.body, g, w)
+		}
+		var got string
+		if r := rb.reader(); r != nil {
+			b, _ := ioutil.ReadAll(r)
+			got = string(b)
+		}
+		if g, w := got, te.body; g != w {
+			t.Errorf("%v: reader() got %v, want %v", te.body, g, w)
+		}
+	}
+}
+
+func TestRetrier_ShouldRetry(t *testing.T) {
+	params := &config.Retry{Retries: 2, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second, StatusCodes: []int{503}}
+	replayable := &requestBody{replayable: true}
+	get := httptest.NewRequest("GET", "http://example.com", nil)
+	post := httptest.NewRequest("POST", "http://example.com", nil)
+	res503 := &http.Response{StatusCode: 503}
+	res500 := &http.Response{StatusCode: 500}
+
+	tt := []struct {
+		name          string
+		orig          *http.Request
+		body          *requestBody
+		attempt       int
+		res           *http.Response
+		err           error
+		budget        time.Duration
+		nonIdempotent bool
+		want          bool
+	}{
+		{name: "GET error", orig: get, body: replayable, attempt: 1, err: errTest, want: true},
+		{name: "POST error", orig: post, body: replayable, attempt: 1, err: errTest, want: false},
+		{name: "POST 503", orig: post, body: replayable, attempt: 1, res: res503, want: false},
+		{name: "POST 503 non-idempotent", orig: post, body: replayable, attempt: 1, res: res503, nonIdempotent: true, want: true},
+		{name: "POST error non-idempotent", orig: post, body: replayable, attempt: 1, err: errTest, nonIdempotent: true, want: false},
+		{name: "GET 503", orig: get, body: replayable, attempt: 1, res: res503, want: true},
+		{name: "GET 500", orig: get, body: replayable, attempt: 1, res: res500, want: false},
+		{name: "exhausted", orig: get, body: replayable, attempt: 3, err: errTest, want: false},
+		{name: "not replayable", orig: get, body: &requestBody{}, attempt: 1, err: errTest, want: false},
+		{name: "budget", orig: get, body: replayable, attempt: 1, err: errTest, budget: time.Millisecond, want: false},
+	}
+	for _, te := range tt {
+		p := *params
+		p.Budget = te.budget
+		p.RetryNonIdempotent = te.nonIdempotent
+		got, _ := newRetrier(&p).shouldRetry(te.orig, te.body, te.attempt, te.res, te.err)
+		if g, w := got, te.want; g != w {
+			t.Errorf("%v: got %v, want %v", te.name, g, w)
+		}
+	}
+}
+
+func TestRetrier_Backoff(t *testing.T) {
+	r := newRetrier(&config.Retry{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second})
+	tt := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{attempt: 1, max: 100 * time.Millisecond},
+		{attempt: 2, max: 200 * time.Millisecond},
+		{attempt: 3, max: 400 * time.Millisecond},
+		{attempt: 10, max: time.Second},
+	}
+	for _, te := range tt {
+		for i := 0; i < 10; i++ {
+			got := r.backoff(te.attempt)
+			if got < te.max/2 || got >= te.max {
+				t.Errorf("attempt %v: got %v, want [%v, %v)", te.attempt, got, te.max/2, te.max)
+			}
+		}
+	}
+}
+
+var errTest = testError("test error")
+
+type testError string
+
+func (e testError) Error() string { return string(e) }
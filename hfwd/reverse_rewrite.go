@@ -0,0 +1,150 @@
+package hfwd
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// reverseRewrite rewrites the URLs in the response headers, which point at the upstreams, back to the listener of the orig.
+// The ups is the upstream which returned the res.
+func (s *server) reverseRewrite(orig *http.Request, res *http.Response, ups *url.URL) {
+	params := &s.params.ReverseRewrite
+	if params.RewriteLocation {
+		for _, name := range []string{"Location", "Content-Location"} {
+			if v := res.Header.Get(name); v != "" {
+				res.Header.Set(name, s.reverseURL(orig, ups, v))
+			}
+		}
+		if v := res.Header.Get("Refresh"); v != "" {
+			res.Header.Set("Refresh", s.reverseRefresh(orig, ups, v))
+		}
+	}
+	if params.RewriteCookie {
+		cookies := res.Header["Set-Cookie"]
+		for i, v := range cookies {
+			cookies[i] = s.reverseCookie(ups, v)
+		}
+	}
+}
+
+// reverseURL rewrites the v to the URL of the listener if the v points at one of the upstreams.
+// The path-absolute reference is regarded as the path of the ups.
+func (s *server) reverseURL(orig *http.Request, ups *url.URL, v string) string {
+	u, err := url.Parse(v)
+	if err != nil {
+		return v
+	}
+	if u.Host != "" {
+		if ups = s.upstreamOf(u); ups == nil {
+			// points at the other servers
+			return v
+		}
+		if u.Scheme != "" {
+			u.Scheme = "http"
+			if orig.TLS != nil {
+				u.Scheme = "https"
+			}
+		}
+		u.Host = orig.Host
+		u.User = nil
+	} else if u.Scheme != "" || !strings.HasPrefix(u.Path, "/") {
+		// the relative path is resolved by the client
+		return v
+	}
+	u.Path = s.reversePath(ups, u.Path)
+	u.RawPath = ""
+	return u.String()
+}
+
+// reverseRefresh rewrites the URL in the Refresh header such as "5; url=https://example.com/"
+func (s *server) reverseRefresh(orig *http.Request, ups *url.URL, v string) string {
+	i := strings.Index(strings.ToLower(v), "url=")
+	if i < 0 {
+		return v
+	}
+	prefix, target := v[:i+len("url=")], strings.TrimSpace(v[i+len("url="):])
+	var quote string
+	if n := len(target); n >= 2 && (target[0] == '\'' || target[0] == '"') && target[n-1] == target[0] {
+		quote, target = target[:1], target[1:n-1]
+	}
+	return prefix + quote + s.reverseURL(orig, ups, target) + quote
+}
+
+// reverseCookie removes the Domain attribute of the ups from the Set-Cookie value so that the cookie belongs to the listener,
+// and rewrites the Path attribute back to the path of the listener
+func (s *server) reverseCookie(ups *url.URL, v string) string {
+	attrs := strings.Split(v, ";")
+	rewritten := attrs[:1]
+	for _, attr := range attrs[1:] {
+		name, val := attr, ""
+		if i := strings.IndexByte(attr, '='); i >= 0 {
+			name, val = attr[:i], attr[i+1:]
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "domain":
+			if domainMatch(ups.Hostname(), strings.TrimSpace(val)) {
+				// the host-only cookie of the listener
+				continue
+			}
+		case "path":
+			attr = name + "=" + s.reversePath(ups, strings.TrimSpace(val))
+		}
+		rewritten = append(rewritten, attr)
+	}
+	return strings.Join(rewritten, ";")
+}
+
+// reversePath rewrites the path of the ups back to the path of the listener.
+// The base path of the ups is removed, and the reverse of the path rewrite rules is applied.
+func (s *server) reversePath(ups *url.URL, p string) string {
+	if base := strings.TrimSuffix(ups.Path, "/"); base != "" {
+		if p != base && !strings.HasPrefix(p, base+"/") {
+			// out of the base path
+			return p
+		}
+		if p = strings.TrimPrefix(p, base); p == "" {
+			p = "/"
+		}
+	}
+	u := &url.URL{Path: p}
+	for _, rewrite := range s.params.ReversePathRewriters() {
+		if ok := rewrite.Do(u); ok {
+			break
+		}
+	}
+	return u.Path
+}
+
+// upstreamOf returns the URL of the upstream which the u points at, or nil
+func (s *server) upstreamOf(u *url.URL) *url.URL {
+	for _, ups := range s.upstreams.all {
+		if u.Scheme != "" && !strings.EqualFold(u.Scheme, ups.url.Scheme) {
+			continue
+		}
+		if strings.EqualFold(hostPort(u, ups.url.Scheme), hostPort(ups.url, ups.url.Scheme)) {
+			return ups.url
+		}
+	}
+	return nil
+}
+
+// hostPort returns the host:port of the u. The port defaults to the one of the scheme
+func hostPort(u *url.URL, scheme string) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// domainMatch reports whether the host belongs to the cookie domain
+func domainMatch(host, domain string) bool {
+	host = strings.ToLower(host)
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	return domain != "" && (host == domain || strings.HasSuffix(host, "."+domain))
+}
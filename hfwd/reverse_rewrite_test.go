@@ -0,0 +1,81 @@
+package hfwd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestServer_reverseRewrite(t *testing.T) {
+	var dstURL string
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base/new/login":
+			w.Header().Set("Location", dstURL+"/base/new/home?from=login")
+			w.Header().Set("Content-Location", "/base/new/login.html")
+			w.Header().Set("Refresh", "5; url='"+dstURL+"/base/new/home'")
+			w.Header().Add("Set-Cookie", "sid=1; Domain=127.0.0.1; Path=/base/new/home; HttpOnly")
+			w.Header().Add("Set-Cookie", "other=1; Domain=example.com; Path=/")
+			w.WriteHeader(http.StatusFound)
+		case "/base/new/away":
+			w.Header().Set("Location", "https://example.com/base/new/home")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer dstServer.Close()
+	dstURL = dstServer.URL
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	params := configParam(
+		config.URL{RewritePaths: map[string]string{"^/old/(.+)$": "/new/$1"}},
+		config.ReverseRewrite{RewriteLocation: true, RewriteCookie: true},
+	)
+	withRunProxy(dstURL+"/base", params, func(proxyURL string) {
+		t.Run("destination", func(t *testing.T) {
+			res, err := client.Get(proxyURL + "/old/login")
+			if err != nil {
+				t.Fatalf("failed to GET: %v", err)
+			}
+			defer res.Body.Close()
+			if g, w := res.StatusCode, http.StatusFound; g != w {
+				t.Errorf("res.StatusCode got %v, want %v", g, w)
+			}
+			want := http.Header{
+				"Location":         {proxyURL + "/old/home?from=login"},
+				"Content-Location": {"/old/login.html"},
+				"Refresh":          {"5; url='" + proxyURL + "/old/home'"},
+				"Set-Cookie":       {"sid=1; Path=/old/home; HttpOnly", "other=1; Domain=example.com; Path=/"},
+			}
+			for name, vv := range want {
+				if g, w := len(res.Header[name]), len(vv); g != w {
+					t.Errorf("len(%v) got %v, want %v", name, g, w)
+					continue
+				}
+				for i, v := range vv {
+					if g, w := res.Header[name][i], v; g != w {
+						t.Errorf("%v got %v, want %v", name, g, w)
+					}
+				}
+			}
+		})
+
+		t.Run("other server", func(t *testing.T) {
+			res, err := client.Get(proxyURL + "/old/away")
+			if err != nil {
+				t.Fatalf("failed to GET: %v", err)
+			}
+			defer res.Body.Close()
+			if g, w := res.Header.Get("Location"), "https://example.com/base/new/home"; g != w {
+				t.Errorf("Location got %v, want %v", g, w)
+			}
+		})
+	})
+}
@@ -0,0 +1,112 @@
+package hfwd
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// NewRouter returns Handler which forwards requests to the destination of the matched route.
+// Routes which have the Host are matched prior to routes which have not,
+// and then the longest path prefix wins.
+func NewRouter(routes []*config.Route) (Handler, error) {
+	rt := &router{}
+	for _, r := range routes {
+		h, err := NewLoadBalancingHandler(r.DestinationURLs(), &r.Parameters)
+		if err != nil {
+			rt.Close()
+			return nil, err
+		}
+		rt.entries = append(rt.entries, &routeEntry{
+			name:    r.Name(),
+			host:    strings.ToLower(r.Host),
+			prefix:  r.PathPrefix,
+			handler: h,
+		})
+	}
+	sort.SliceStable(rt.entries, func(i, j int) bool {
+		ei, ej := rt.entries[i], rt.entries[j]
+		if (ei.host != "") != (ej.host != "") {
+			return ei.host != ""
+		}
+		return len(ei.prefix) > len(ej.prefix)
+	})
+	return rt, nil
+}
+
+type router struct {
+	entries []*routeEntry
+}
+
+type routeEntry struct {
+	name    string
+	host    string
+	prefix  string
+	handler Handler
+}
+
+func (rt *router) Close() error {
+	for _, e := range rt.entries {
+		e.handler.Close()
+	}
+	return nil
+}
+
+func (rt *router) upstreamStatuses() []UpstreamStatus {
+	var ss []UpstreamStatus
+	for _, e := range rt.entries {
+		r, ok := e.handler.(upstreamsReporter)
+		if !ok {
+			continue
+		}
+		for _, st := range r.upstreamStatuses() {
+			st.Route = e.name
+			ss = append(ss, st)
+		}
+	}
+	return ss
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, e := range rt.entries {
+		if e.match(r) {
+			e.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	log.Printf("hfwd: no route matches to the request %v%v", r.Host, r.URL.Path)
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (e *routeEntry) match(r *http.Request) bool {
+	return e.matchHost(r.Host) && e.matchPath(r.URL.Path)
+}
+
+func (e *routeEntry) matchHost(host string) bool {
+	if e.host == "" {
+		return true
+	}
+	host = strings.ToLower(host)
+	if strings.Contains(e.host, ":") {
+		return host == e.host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return host == e.host
+}
+
+func (e *routeEntry) matchPath(p string) bool {
+	if e.prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(p, e.prefix) {
+		return false
+	}
+	// matches to /api, /api/ and /api/foo, but not /apifoo
+	return len(p) == len(e.prefix) || strings.HasSuffix(e.prefix, "/") || p[len(e.prefix)] == '/'
+}
@@ -0,0 +1,84 @@
+package hfwd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func TestRouter(t *testing.T) {
+	newDst := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		}))
+	}
+	def, api, apiV2, static := newDst("default"), newDst("api"), newDst("apiV2"), newDst("static")
+	defer def.Close()
+	defer api.Close()
+	defer apiV2.Close()
+	defer static.Close()
+
+	routes := []*config.Route{
+		{Destination: def.URL},
+		{Host: "api.localhost", Destination: api.URL},
+		{Host: "api.localhost", PathPrefix: "/v2", Destination: apiV2.URL},
+		{PathPrefix: "/static", Destination: static.URL},
+	}
+	for _, r := range routes {
+		if err := r.Setup(); err != nil {
+			t.Fatalf("failed to setup route: %v", err)
+		}
+	}
+	h, err := NewRouter(routes)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	proxyServer := httptest.NewServer(h)
+	defer proxyServer.Close()
+
+	tt := []struct {
+		host string
+		path string
+		want string
+	}{
+		{host: "", path: "/foo", want: "default /foo"},
+		{host: "", path: "/static/app.js", want: "static /static/app.js"},
+		{host: "", path: "/staticfoo", want: "default /staticfoo"},
+		{host: "api.localhost", path: "/users", want: "api /users"},
+		{host: "API.localhost:8080", path: "/v2/users", want: "apiV2 /v2/users"},
+		{host: "api.localhost", path: "/static/app.js", want: "api /static/app.js"},
+	}
+	for _, te := range tt {
+		req, _ := http.NewRequest("GET", proxyServer.URL+te.path, nil)
+		if te.host != "" {
+			req.Host = te.host
+		}
+		res, err := http.DefaultClient.Do(req)
+		assertOKResponse(t, res, err)
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if g, w := string(b), te.want; g != w {
+			t.Errorf("%v%v: got %v, want %v", te.host, te.path, g, w)
+		}
+	}
+}
+
+func TestRouter_NotFound(t *testing.T) {
+	r := &config.Route{Host: "api.localhost", Destination: "http://127.0.0.1:1"}
+	if err := r.Setup(); err != nil {
+		t.Fatalf("failed to setup route: %v", err)
+	}
+	h, err := NewRouter([]*config.Route{r})
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/foo", nil))
+	if g, w := rec.Code, http.StatusNotFound; g != w {
+		t.Errorf("status got %v, want %v", g, w)
+	}
+}
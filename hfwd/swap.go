@@ -0,0 +1,58 @@
+package hfwd
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// SwapHandler is Handler which delegates the requests to the handler swapped atomically.
+// The in-flight requests are served by the old handler until they complete.
+type SwapHandler struct {
+	mu sync.Mutex // serializes the Swap and the Close
+	v  atomic.Value
+}
+
+type handlerBox struct {
+	h Handler
+}
+
+// NewSwapHandler returns SwapHandler which delegates the requests to the h
+func NewSwapHandler(h Handler) *SwapHandler {
+	s := &SwapHandler{}
+	s.v.Store(handlerBox{h: h})
+	return s
+}
+
+// Swap swaps the handler to the h, and closes the old handler
+func (s *SwapHandler) Swap(h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old := s.current()
+	s.v.Store(handlerBox{h: h})
+	old.Close()
+}
+
+func (s *SwapHandler) current() Handler {
+	return s.v.Load().(handlerBox).h
+}
+
+// Close closes the current handler
+func (s *SwapHandler) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current().Close()
+}
+
+func (s *SwapHandler) upstreamStatuses() []UpstreamStatus {
+	r, ok := s.current().(upstreamsReporter)
+	if !ok {
+		return nil
+	}
+	return r.upstreamStatuses()
+}
+
+// ServeHTTP implements http.Handler
+func (s *SwapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.current().ServeHTTP(w, r)
+}
@@ -0,0 +1,69 @@
+package hfwd
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type closeRecorder struct {
+	http.Handler
+	closed bool
+}
+
+func (h *closeRecorder) Close() error {
+	h.closed = true
+	return nil
+}
+
+func TestSwapHandler(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	old := &closeRecorder{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("old"))
+	})}
+	newer := &closeRecorder{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	})}
+
+	h := NewSwapHandler(old)
+	serv := httptest.NewServer(h)
+	defer serv.Close()
+
+	get := func() string {
+		res, err := http.Get(serv.URL)
+		if err != nil {
+			t.Errorf("failed to GET: %v", err)
+			return ""
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return string(b)
+	}
+
+	inflight := make(chan string)
+	go func() { inflight <- get() }()
+	<-started
+
+	h.Swap(newer)
+	if !old.closed {
+		t.Errorf("the old handler is not closed")
+	}
+	if g, w := get(), "new"; g != w {
+		t.Errorf("body got %v, want %v", g, w)
+	}
+
+	// the in-flight request is served by the old handler
+	close(release)
+	if g, w := <-inflight, "old"; g != w {
+		t.Errorf("in-flight body got %v, want %v", g, w)
+	}
+
+	h.Close()
+	if !newer.closed {
+		t.Errorf("the current handler is not closed")
+	}
+}
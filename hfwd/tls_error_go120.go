@@ -0,0 +1,13 @@
+//go:build go1.20
+// +build go1.20
+
+package hfwd
+
+import "crypto/tls"
+
+// isCertificateVerificationError reports whether the err is the failure of the certificate verification.
+// The x509 errors are wrapped by the tls.CertificateVerificationError since Go 1.20.
+func isCertificateVerificationError(err error) bool {
+	_, ok := err.(*tls.CertificateVerificationError)
+	return ok
+}
@@ -0,0 +1,10 @@
+//go:build !go1.20
+// +build !go1.20
+
+package hfwd
+
+// isCertificateVerificationError reports whether the err is the failure of the certificate verification.
+// The x509 errors are returned as they are before Go 1.20.
+func isCertificateVerificationError(err error) bool {
+	return false
+}
@@ -0,0 +1,215 @@
+package hfwd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// isUpgradeRequest reports whether the req asks to upgrade the protocol such as the WebSocket
+func isUpgradeRequest(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != "" && headerHasToken(req.Header, "Connection", "upgrade")
+}
+
+// headerHasToken reports whether the comma separated values of the header name contain the token (case-insensitive)
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h[name] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// tlsHandshakeTimeoutError is returned when the TLS handshake with the upstream times out
+type tlsHandshakeTimeoutError struct{}
+
+func (tlsHandshakeTimeoutError) Timeout() bool   { return true }
+func (tlsHandshakeTimeoutError) Temporary() bool { return true }
+func (tlsHandshakeTimeoutError) Error() string   { return "hfwd: TLS handshake timeout" }
+
+// serveUpgrade performs the upgrade handshake with the upstream, and tunnels the bytes between the client and the upstream.
+// The RequestTimeout of the ctx applies to the handshake only, the tunnel lasts until either side closes.
+func (s *server) serveUpgrade(ctx context.Context, w http.ResponseWriter, orig *http.Request) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		log.Printf("hfwd: the connection of the upgrade request %v can not be hijacked", orig.URL)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req, err := http.NewRequest(orig.Method, orig.URL.String(), nil)
+	if err != nil {
+		log.Printf("hfwd: failed to create a new request: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tmpl := newHeaderTemplate(orig)
+	s.copyHeader(orig, req)
+	s.setForwardedHeaders(orig, req)
+	s.rewriteHeader(req, tmpl)
+	s.setClientCertHeaders(orig, req)
+	// restores the hop-by-hop headers for the handshake
+	req.Header.Set("Upgrade", orig.Header.Get("Upgrade"))
+	req.Header.Set("Connection", "Upgrade")
+
+	ups := s.upstreams.next()
+	if ups == nil {
+		log.Printf("hfwd: no available upstream for the request %v", orig.URL)
+		writeError(w, &s.params.ErrorResponse, &gatewayError{
+			Status:  http.StatusServiceUnavailable,
+			Stage:   stageUpstream,
+			Message: "no available upstream",
+		})
+		return
+	}
+	ups.acquire()
+	defer ups.release()
+	s.rewriteURL(req.URL, ups.url)
+	if u := req.URL.User; u != nil && req.Header.Get("Authorization") == "" {
+		pw, _ := u.Password()
+		req.SetBasicAuth(u.Username(), pw)
+	}
+
+	upConn, br, res, err := s.handshakeUpgrade(ctx, req)
+	if err != nil {
+		log.Printf("hfwd: an error occurrd while upgrading the request: %v", err)
+		if isUpstreamFailure(ctx, err) {
+			s.upstreams.fail(ups, err)
+		}
+		writeError(w, &s.params.ErrorResponse, newGatewayError(ups.url, err, s.params.Verbose))
+		return
+	}
+	defer upConn.Close()
+	s.upstreams.succeed(ups)
+	s.rewriteResponseHeader(res, tmpl)
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		// the upstream refused the upgrade
+		s.reverseRewrite(orig, res, ups.url)
+		s.removeCORSHeaders(res)
+		s.writeResponse(w, res)
+		return
+	}
+	if !strings.EqualFold(res.Header.Get("Upgrade"), req.Header.Get("Upgrade")) {
+		err := fmt.Errorf("hfwd: the upstream switched to the protocol %q, but %q is requested", res.Header.Get("Upgrade"), req.Header.Get("Upgrade"))
+		log.Print(err)
+		writeError(w, &s.params.ErrorResponse, newGatewayError(ups.url, err, s.params.Verbose))
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Printf("hfwd: failed to hijack the connection of the upgrade request %v: %v", orig.URL, err)
+		return
+	}
+	defer conn.Close()
+	if err := res.Write(conn); err != nil {
+		log.Printf("hfwd: failed to write the upgrade response: %v", err)
+		return
+	}
+	if s.params.Verbose {
+		log.Printf("hfwd upgraded the connection to %v (%v)", redactURL(req.URL), res.Header.Get("Upgrade"))
+	}
+
+	// the buffered readers may hold the bytes that arrived right after the handshake
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(upConn, brw.Reader)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, br)
+		errc <- err
+	}()
+	// closing either side by the deferred Close stops the other copy
+	<-errc
+}
+
+// handshakeUpgrade sends the upgrade request to the upstream and reads the response headers.
+// The returned reader has the bytes of the upstream connection following the response headers.
+func (s *server) handshakeUpgrade(ctx context.Context, req *http.Request) (net.Conn, *bufio.Reader, *http.Response, error) {
+	conn, err := s.dialUpstream(ctx, req)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// cancels the handshake when the ctx is done
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if d := s.params.ResponseHeaderTimeout; d > 0 {
+		conn.SetReadDeadline(time.Now().Add(d))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, nil, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, br, res, nil
+}
+
+// dialUpstream connects to the upstream of the req, and performs the TLS handshake when the scheme is https
+func (s *server) dialUpstream(ctx context.Context, req *http.Request) (net.Conn, error) {
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	dialer := &net.Dialer{Timeout: s.params.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+
+	cfg := &tls.Config{}
+	if c := s.params.TLSClientConfig(); c != nil {
+		cfg = c.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	// the upgrade is defined only in HTTP/1.1
+	cfg.NextProtos = nil
+	tlsConn := tls.Client(conn, cfg)
+	if d := s.params.TLSHandshakeTimeout; d > 0 {
+		tlsConn.SetDeadline(time.Now().Add(d))
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil, tlsHandshakeTimeoutError{}
+		}
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
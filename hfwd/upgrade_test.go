@@ -0,0 +1,118 @@
+package hfwd
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// echoUpgradeHandler switches to the "echo" protocol which echoes back the lines
+func echoUpgradeHandler(t *testing.T) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgradeRequest(r) || r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\nX-Echo: " + r.Header.Get("X-Echo") + "\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	})
+}
+
+func dialUpgrade(t *testing.T, proxyURL, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	req, _ := http.NewRequest("GET", proxyURL+"/ws", nil)
+	req.Header.Set("Upgrade", protocol)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("X-Echo", "hello")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to write the request: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	return conn, br, res
+}
+
+func TestServer_ServeHTTP_Upgrade(t *testing.T) {
+	tt := []struct {
+		name      string
+		newServer func(http.Handler) *httptest.Server
+	}{
+		{name: "http", newServer: httptest.NewServer},
+		{name: "https", newServer: httptest.NewTLSServer},
+	}
+	for _, te := range tt {
+		t.Run(te.name, func(t *testing.T) {
+			dstServer := te.newServer(echoUpgradeHandler(t))
+			defer dstServer.Close()
+
+			withRunProxy(dstServer.URL, configParam(config.TLSClient{Insecure: true}), func(proxyURL string) {
+				conn, br, res := dialUpgrade(t, proxyURL, "echo")
+				defer conn.Close()
+				if g, w := res.StatusCode, http.StatusSwitchingProtocols; g != w {
+					t.Fatalf("res.StatusCode got %v, want %v", g, w)
+				}
+				if g, w := res.Header.Get("X-Echo"), "hello"; g != w {
+					t.Errorf("X-Echo got %v, want %v", g, w)
+				}
+				for _, msg := range []string{"ping\n", "pong\n"} {
+					if _, err := io.WriteString(conn, msg); err != nil {
+						t.Fatalf("failed to write: %v", err)
+					}
+					line, err := br.ReadString('\n')
+					if err != nil {
+						t.Fatalf("failed to read: %v", err)
+					}
+					if g, w := line, msg; g != w {
+						t.Errorf("echo got %q, want %q", g, w)
+					}
+				}
+			})
+		})
+	}
+
+	t.Run("refused by the upstream", func(t *testing.T) {
+		dstServer := httptest.NewServer(echoUpgradeHandler(t))
+		defer dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			conn, _, res := dialUpgrade(t, proxyURL, "unknown")
+			defer conn.Close()
+			if g, w := res.StatusCode, http.StatusBadRequest; g != w {
+				t.Errorf("res.StatusCode got %v, want %v", g, w)
+			}
+		})
+	})
+
+	t.Run("upstream unreachable", func(t *testing.T) {
+		dstServer := httptest.NewServer(echoUpgradeHandler(t))
+		dstServer.Close()
+
+		withRunProxy(dstServer.URL, configParam(), func(proxyURL string) {
+			conn, _, res := dialUpgrade(t, proxyURL, "echo")
+			defer conn.Close()
+			if g, w := res.StatusCode, http.StatusBadGateway; g != w {
+				t.Errorf("res.StatusCode got %v, want %v", g, w)
+			}
+		})
+	})
+}
@@ -0,0 +1,213 @@
+package hfwd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+// upstream is an upstream server of the destination
+type upstream struct {
+	url         *url.URL
+	outstanding int64 // count of the outstanding requests. must be accessed atomically
+
+	mu           sync.Mutex
+	unhealthy    bool      // result of the active health check
+	fails        int       // count of the consecutive transport errors
+	ejected      bool      // whether the upstream is ejected by the transport errors
+	ejectedUntil time.Time // the ejected upstream is brought back after this time
+}
+
+// upstreams is a set of the upstream servers, which selects an upstream server for each request
+type upstreams struct {
+	all    []*upstream
+	policy balancePolicy
+
+	maxFails     int
+	failCooldown time.Duration
+}
+
+func newUpstreams(dsts []*url.URL, params *config.Upstream) (*upstreams, error) {
+	us := &upstreams{maxFails: params.MaxFails, failCooldown: params.FailCooldown}
+	for _, dst := range dsts {
+		us.all = append(us.all, &upstream{url: dst})
+	}
+	switch params.Balance {
+	case config.BalanceRoundRobin, "":
+		us.policy = &roundRobin{}
+	case config.BalanceRandom:
+		us.policy = &random{}
+	case config.BalanceLeastOutstanding:
+		us.policy = &leastOutstanding{}
+	default:
+		return nil, fmt.Errorf("hfwd: unknown load balancing policy %v", params.Balance)
+	}
+	return us, nil
+}
+
+// next selects the upstream server for the next request from the available upstreams.
+// next returns nil if there is no available upstream.
+func (us *upstreams) next() *upstream {
+	now := time.Now()
+	candidates := make([]*upstream, 0, len(us.all))
+	for _, u := range us.all {
+		if u.available(now) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return us.policy.pick(candidates)
+}
+
+// succeed records the success of the request to the upstream
+func (us *upstreams) succeed(u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+}
+
+// fail records the transport error of the request to the upstream,
+// and ejects the upstream if the consecutive errors reach the max fails.
+func (us *upstreams) fail(u *upstream, err error) {
+	if us.maxFails <= 0 {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails < us.maxFails {
+		return
+	}
+	u.fails = 0
+	u.ejected = true
+	u.ejectedUntil = time.Now().Add(us.failCooldown)
+	log.Printf("hfwd: upstream %v is ejected for %v by %v consecutive errors: %v", u.url, us.failCooldown, us.maxFails, err)
+}
+
+// isUpstreamFailure reports whether the err of the request within the ctx is caused by the upstream.
+// The cancellation by the client and the expiry of the RequestTimeout are not the failures of the upstream,
+// whereas the timeouts of the transport such as the dial, the TLS handshake and the response headers are.
+func isUpstreamFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if ue, ok := err.(*url.Error); ok {
+		err = ue.Err
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded
+}
+
+// setHealthy records the result of the active health check
+func (u *upstream) setHealthy(healthy bool, reason string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.unhealthy == !healthy {
+		return
+	}
+	u.unhealthy = !healthy
+	if healthy {
+		log.Printf("hfwd: upstream %v is healthy", u.url)
+	} else {
+		log.Printf("hfwd: upstream %v is unhealthy: %v", u.url, reason)
+	}
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.ejected && !now.Before(u.ejectedUntil) {
+		u.ejected = false
+		log.Printf("hfwd: upstream %v is brought back after the cooldown", u.url)
+	}
+	return !u.unhealthy && !u.ejected
+}
+
+// UpstreamStatus is the status of an upstream server
+type UpstreamStatus struct {
+	Route        string     `json:"route,omitempty"`
+	URL          string     `json:"url"`
+	Available    bool       `json:"available"`
+	Healthy      bool       `json:"healthy"`
+	Fails        int        `json:"fails"`
+	EjectedUntil *time.Time `json:"ejectedUntil,omitempty"`
+	Outstanding  int64      `json:"outstanding"`
+}
+
+func (us *upstreams) statuses() []UpstreamStatus {
+	now := time.Now()
+	ss := make([]UpstreamStatus, 0, len(us.all))
+	for _, u := range us.all {
+		available := u.available(now)
+		u.mu.Lock()
+		st := UpstreamStatus{
+			URL:         u.url.String(),
+			Available:   available,
+			Healthy:     !u.unhealthy,
+			Fails:       u.fails,
+			Outstanding: atomic.LoadInt64(&u.outstanding),
+		}
+		if u.ejected {
+			until := u.ejectedUntil
+			st.EjectedUntil = &until
+		}
+		u.mu.Unlock()
+		ss = append(ss, st)
+	}
+	return ss
+}
+
+// acquire marks the start of the request to the upstream
+func (u *upstream) acquire() {
+	atomic.AddInt64(&u.outstanding, 1)
+}
+
+// release marks the end of the request to the upstream
+func (u *upstream) release() {
+	atomic.AddInt64(&u.outstanding, -1)
+}
+
+// balancePolicy is an interface to the load balancing policy
+type balancePolicy interface {
+	// pick an upstream from the candidates. candidates must not be empty
+	pick(candidates []*upstream) *upstream
+}
+
+type roundRobin struct {
+	n uint64
+}
+
+func (p *roundRobin) pick(candidates []*upstream) *upstream {
+	n := atomic.AddUint64(&p.n, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+type random struct{}
+
+func (p *random) pick(candidates []*upstream) *upstream {
+	return candidates[rand.Intn(len(candidates))]
+}
+
+type leastOutstanding struct {
+	// rr breaks ties so that the requests are not concentrated on the first upstream
+	rr roundRobin
+}
+
+func (p *leastOutstanding) pick(candidates []*upstream) *upstream {
+	start := p.rr.pick(candidates)
+	least := start
+	for _, u := range candidates {
+		if atomic.LoadInt64(&u.outstanding) < atomic.LoadInt64(&least.outstanding) {
+			least = u
+		}
+	}
+	return least
+}
@@ -0,0 +1,151 @@
+package hfwd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/kei2100/h-fwd/config"
+)
+
+func newTestUpstreams(t *testing.T, balance string, n int) *upstreams {
+	t.Helper()
+	var dsts []*url.URL
+	for i := 0; i < n; i++ {
+		dsts = append(dsts, mustURL(fmt.Sprintf("http://upstream%d.example.com", i)))
+	}
+	us, err := newUpstreams(dsts, &config.Upstream{Balance: balance})
+	if err != nil {
+		t.Fatalf("failed to create upstreams: %v", err)
+	}
+	return us
+}
+
+func TestRoundRobin(t *testing.T) {
+	us := newTestUpstreams(t, config.BalanceRoundRobin, 3)
+	for i := 0; i < 6; i++ {
+		if g, w := us.next(), us.all[i%3]; g != w {
+			t.Errorf("%v: got %v, want %v", i, g.url, w.url)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	us := newTestUpstreams(t, config.BalanceRandom, 3)
+	picked := make(map[*upstream]int)
+	for i := 0; i < 300; i++ {
+		picked[us.next()]++
+	}
+	if g, w := len(picked), 3; g != w {
+		t.Errorf("len(picked) got %v, want %v", g, w)
+	}
+}
+
+func TestLeastOutstanding(t *testing.T) {
+	us := newTestUpstreams(t, config.BalanceLeastOutstanding, 3)
+	us.all[0].acquire()
+	us.all[0].acquire()
+	us.all[2].acquire()
+	for i := 0; i < 3; i++ {
+		if g, w := us.next(), us.all[1]; g != w {
+			t.Errorf("%v: got %v, want %v", i, g.url, w.url)
+		}
+	}
+	us.all[1].acquire()
+	us.all[1].acquire()
+	if g, w := us.next(), us.all[2]; g != w {
+		t.Errorf("got %v, want %v", g.url, w.url)
+	}
+}
+
+func TestNewUpstreams_UnknownPolicy(t *testing.T) {
+	_, err := newUpstreams([]*url.URL{mustURL("http://example.com")}, &config.Upstream{Balance: "unknown"})
+	if err == nil {
+		t.Errorf("want an error, got nil")
+	}
+}
+
+func TestNewLoadBalancingHandler(t *testing.T) {
+	newDst := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	dst1, dst2 := newDst("dst1"), newDst("dst2")
+	defer dst1.Close()
+	defer dst2.Close()
+
+	h, err := NewLoadBalancingHandler([]*url.URL{mustURL(dst1.URL), mustURL(dst2.URL)}, configParam())
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	proxyServer := httptest.NewServer(h)
+	defer proxyServer.Close()
+
+	for _, want := range []string{"dst1", "dst2", "dst1"} {
+		res, err := http.Get(proxyServer.URL)
+		assertOKResponse(t, res, err)
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if g, w := string(b), want; g != w {
+			t.Errorf("res.Body got %v, want %v", g, w)
+		}
+	}
+}
+
+func TestServer_ServeHTTP_PassiveHealthIgnoresCancel(t *testing.T) {
+	canceled := make(chan struct{})
+	dstServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+			close(canceled)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer dstServer.Close()
+
+	tt := []struct {
+		name   string
+		params *config.Parameters
+		cancel bool
+	}{
+		{name: "client cancels", params: configParam(config.Upstream{MaxFails: 1}), cancel: true},
+		{name: "request timeout", params: configParam(config.Upstream{MaxFails: 1}, config.Timeouts{RequestTimeout: 50 * time.Millisecond})},
+	}
+	for _, te := range tt {
+		t.Run(te.name, func(t *testing.T) {
+			canceled = make(chan struct{})
+			withRunProxy(dstServer.URL, te.params, func(proxyURL string) {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				req, _ := http.NewRequest("GET", proxyURL+"/slow", nil)
+				errc := make(chan error, 1)
+				go func() {
+					res, err := http.DefaultClient.Do(req.WithContext(ctx))
+					if err == nil {
+						res.Body.Close()
+					}
+					errc <- err
+				}()
+				if te.cancel {
+					time.Sleep(50 * time.Millisecond)
+					cancel()
+				}
+				<-errc
+				<-canceled
+				// waits for the proxy to record the result of the request
+				time.Sleep(50 * time.Millisecond)
+
+				res, err := http.Get(proxyURL + "/fast")
+				assertOKResponse(t, res, err)
+				res.Body.Close()
+			})
+		})
+	}
+}